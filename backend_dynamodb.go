@@ -1,17 +1,43 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 )
 
+// the item attribute which holds the KMS encryption context used to encrypt the value
+const contextAttr = "context"
+
+// the item attributes which hold the KMS encrypted data key and AES-GCM nonce when using envelope encryption
+const (
+	dataKeyAttr = "data_key"
+	nonceAttr   = "nonce"
+)
+
+// the values which can be written into the sort key of tables with a composite primary key
+const (
+	sortKeyVersion   = "version"
+	sortKeyTimestamp = "timestamp"
+)
+
+// sort key values stored as strings are zero-padded to 19 digits, so they sort in numeric order
+const sortKeyLen = 19
+
 // DynamoDbBackend is the type for storing a KMS encrypted item attribute in DynamoDB
 type DynamoDbBackend struct {
 	kmsRequired bool
@@ -19,6 +45,15 @@ type DynamoDbBackend struct {
 	k           kmsiface.KMSAPI
 	table       string
 	pk          string
+	sk          string
+	skType      string
+	skValue     string
+	kmsKey      string
+	kmsKeyMap   map[string]string
+	context     map[string]string
+	autoContext bool
+	envelope    bool
+	credstash   bool
 }
 
 // NewDynamoDbBackend creates a basic DynamoDB SecretsBackender.  Note that the table name
@@ -26,15 +61,25 @@ type DynamoDbBackend struct {
 func NewDynamoDbBackend() *DynamoDbBackend {
 	return &DynamoDbBackend{
 		kmsRequired: true,
-		c:           dynamodb.New(ses),
-		k:           kms.New(ses),
+		c:           dynamodb.New(ses, endpointConfig(dynamodb.EndpointsID)),
+		k:           kms.New(ses, endpointConfig(kms.EndpointsID)),
 	}
 }
 
+// WithSession sets the AWS session used to create the DynamoDB and KMS clients, for example to use a different
+// region.  This must be called before WithTable(), since the table is inspected using the DynamoDB client.
+func (b *DynamoDbBackend) WithSession(s *session.Session) *DynamoDbBackend {
+	b.c = dynamodb.New(s, endpointConfig(dynamodb.EndpointsID))
+	b.k = kms.New(s, endpointConfig(kms.EndpointsID))
+	return b
+}
+
 // WithTable sets the DynamoDB table name to store the encrypted value.  The table will be inspected
-// to ensure it exists, and to determine what the Partition/HASH key attribute is
+// to ensure it exists, and to determine what the Partition/HASH key and Sort/RANGE key attributes are.
+// The sort key, if the table has one, must be a String or Number attribute.
 func (b *DynamoDbBackend) WithTable(t string) (*DynamoDbBackend, error) {
 	b.table = t
+	b.pk, b.sk, b.skType = "", "", ""
 
 	i := dynamodb.DescribeTableInput{TableName: aws.String(t)}
 	o, err := b.c.DescribeTable(&i)
@@ -43,14 +88,77 @@ func (b *DynamoDbBackend) WithTable(t string) (*DynamoDbBackend, error) {
 	}
 
 	for _, v := range o.Table.KeySchema {
-		if *v.KeyType == dynamodb.KeyTypeHash {
+		switch *v.KeyType {
+		case dynamodb.KeyTypeHash:
 			b.pk = *v.AttributeName
+		case dynamodb.KeyTypeRange:
+			b.sk = *v.AttributeName
+		}
+	}
+
+	if len(b.sk) > 0 {
+		for _, v := range o.Table.AttributeDefinitions {
+			if *v.AttributeName == b.sk {
+				b.skType = *v.AttributeType
+			}
+		}
+
+		if b.skType != dynamodb.ScalarAttributeTypeS && b.skType != dynamodb.ScalarAttributeTypeN {
+			return nil, fmt.Errorf("unsupported type %s for sort key %s, must be S or N", b.skType, b.sk)
 		}
 	}
 
 	return b, nil
 }
 
+// WithSortKeyValue sets the value written into the sort key of tables with a composite primary key, either
+// "version" (the default) for an incrementing version number, or "timestamp" for the current time in nanoseconds.
+// Each Store() writes a new item, using a conditional write so that concurrent writers never replace the same
+// version, and Fetch() returns the latest version.  String sort key values are zero-padded to 19 digits.
+func (b *DynamoDbBackend) WithSortKeyValue(v string) *DynamoDbBackend {
+	b.skValue = v
+	return b
+}
+
+// WithEncryptionContext sets the KMS encryption context used when encrypting the values
+func (b *DynamoDbBackend) WithEncryptionContext(c map[string]string) *DynamoDbBackend {
+	b.context = c
+	return b
+}
+
+// WithAutoContext adds the table name and the item's partition key value to the KMS encryption context, using
+// the "table" and "key" context keys, so that IAM policy conditions can restrict decryption to specific items
+func (b *DynamoDbBackend) WithAutoContext(a bool) *DynamoDbBackend {
+	b.autoContext = a
+	return b
+}
+
+// WithEnvelope instructs the backend to use envelope encryption, where the value is encrypted locally using
+// AES-256-GCM with a data key from the KMS GenerateDataKey API.  This removes the 4096 byte limit on the size of
+// the value imposed by the KMS Encrypt API, allowing values up to the DynamoDB item size limit.
+func (b *DynamoDbBackend) WithEnvelope(e bool) *DynamoDbBackend {
+	b.envelope = e
+	return b
+}
+
+// WithCredstash instructs the backend to read and write secrets using the credstash item format, for tables
+// created by credstash using the "name" partition key and "version" String sort key.  As with other tables using
+// a sort key, each Store() writes a new version of the secret, and Fetch() returns the latest version.
+func (b *DynamoDbBackend) WithCredstash(c bool) *DynamoDbBackend {
+	b.credstash = c
+	return b
+}
+
+// setKmsKey sets the ARN of the KMS key used to encrypt values when calling Store()
+func (b *DynamoDbBackend) setKmsKey(k string) {
+	b.kmsKey = k
+}
+
+// setKmsKeyMap sets the ARNs of the KMS keys used to encrypt values for each key name prefix when calling Store()
+func (b *DynamoDbBackend) setKmsKeyMap(m map[string]string) {
+	b.kmsKeyMap = m
+}
+
 // KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when
 // doing a Store().  For DynamoDB this will always be true since we need to explicitly do a KMS
 // Encrypt before we store the value in the table.
@@ -61,36 +169,261 @@ func (b *DynamoDbBackend) KmsRequired() bool {
 // Store writes the value to the table using the Partition key defined in the key parameter
 // All attribute values will be stored as String types.  In addition to the Partition key
 // attribute, the "encrypted" attribute will be set on the item with a value of "true", and
-// the "value" attribute will hold the base64 encoded value of the encrypted value.
+// the "value" attribute will hold the base64 encoded value of the encrypted value.  If an encryption
+// context is used, it is stored in the "context" map attribute, so that Fetch() can decrypt the value.
 //
 // KMS limits the size of the encrypted data to 4096 bytes, so attempting to store values larger
-// than that is likely to result in an error.
+// than that is likely to result in an error, unless the backend was configured using WithEnvelope(true).
+// With envelope encryption, the "value" attribute holds the base64 encoded AES-GCM ciphertext, and the
+// "data_key" and "nonce" attributes hold the base64 encoded KMS encrypted data key and the nonce.
 func (b *DynamoDbBackend) Store(key string, value interface{}) error {
-	data, err := b.encrypt(value)
-	if err != nil {
-		return err
+	return b.put(&secret{key: key}, value)
+}
+
+// storeRecord writes the secret value as Store() does, encrypting the value using the KMS key from the
+// secret record if it is set.
+func (b *DynamoDbBackend) storeRecord(s *secret) error {
+	warnUnsupported(dynamoSvc, s, fieldDescription, fieldTags, fieldTier, fieldContentType)
+	return b.put(s, s.value)
+}
+
+func (b *DynamoDbBackend) put(s *secret, value interface{}) error {
+	key := s.key
+	kmsKey := s.kmsKey
+	if len(kmsKey) < 1 {
+		kmsKey = kmsKeyFor(key, b.kmsKeyMap, b.kmsKey)
+	}
+
+	ctx := b.encryptionContext(key)
+
+	if b.credstash {
+		return b.credstashPut(key, value, kmsKey, ctx)
 	}
-	log.Debugf("DynamoDB Encrypted: %s", data)
 
 	i := dynamodb.PutItemInput{
 		TableName: aws.String(b.table),
 		Item: map[string]*dynamodb.AttributeValue{
 			b.pk:        {S: aws.String(key)},
-			"value":     {S: aws.String(data)},
 			"encrypted": {BOOL: aws.Bool(true)},
 		},
 	}
 
+	if b.envelope {
+		data, dataKey, nonce, err := b.envelopeEncrypt(key, value, kmsKey, ctx)
+		if err != nil {
+			return err
+		}
+
+		i.Item["value"] = &dynamodb.AttributeValue{S: aws.String(data)}
+		i.Item[dataKeyAttr] = &dynamodb.AttributeValue{S: aws.String(dataKey)}
+		i.Item[nonceAttr] = &dynamodb.AttributeValue{S: aws.String(nonce)}
+	} else {
+		data, err := b.encrypt(value, kmsKey, ctx)
+		if err != nil {
+			return err
+		}
+		log.Debugf("DynamoDB Encrypted: %s", data)
+
+		i.Item["value"] = &dynamodb.AttributeValue{S: aws.String(data)}
+	}
+
+	if len(ctx) > 0 {
+		m := make(map[string]*dynamodb.AttributeValue, len(ctx))
+		for k, v := range ctx {
+			m[k] = &dynamodb.AttributeValue{S: aws.String(v)}
+		}
+		i.Item[contextAttr] = &dynamodb.AttributeValue{M: m}
+	}
+
+	if len(b.sk) > 0 {
+		v, err := b.nextSortKey(key)
+		if err != nil {
+			return err
+		}
+
+		i.Item[b.sk] = v
+		i.ConditionExpression = aws.String("attribute_not_exists(#pk)")
+		i.ExpressionAttributeNames = map[string]*string{"#pk": aws.String(b.pk)}
+	}
+
 	log.Debugf("writing key %s in DynamoDB table %s", key, b.table)
 	if _, err := b.c.PutItem(&i); err != nil {
-		return err
+		return b.putError(key, i.Item, err)
 	}
 
 	return nil
 }
 
+// a failed conditional write means another writer stored the same version of the secret
+func (b *DynamoDbBackend) putError(key string, item map[string]*dynamodb.AttributeValue, err error) error {
+	if e, ok := err.(awserr.Error); ok && e.Code() == dynamodb.ErrCodeConditionalCheckFailedException && len(b.sk) > 0 {
+		v := item[b.sk]
+		return fmt.Errorf("version %s of %s was written by another writer", sortKeyString(v), key)
+	}
+	return err
+}
+
+// nextSortKey returns the sort key value for a new version of the secret, either the current time in nanoseconds,
+// or the latest version number plus one
+func (b *DynamoDbBackend) nextSortKey(key string) (*dynamodb.AttributeValue, error) {
+	var n int64
+
+	if b.skValue == sortKeyTimestamp {
+		n = time.Now().UnixNano()
+	} else {
+		i := b.versionQuery(key)
+		i.Limit = aws.Int64(1)
+
+		o, err := b.c.Query(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(o.Items) > 0 {
+			v, ok := o.Items[0][b.sk]
+			if !ok || (v.S == nil && v.N == nil) {
+				return nil, fmt.Errorf("item %s is missing the %s attribute", key, b.sk)
+			}
+
+			n, err = strconv.ParseInt(sortKeyString(v), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %s for %s", b.sk, sortKeyString(v), key)
+			}
+		}
+		n++
+	}
+
+	if b.skType == dynamodb.ScalarAttributeTypeN {
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}, nil
+	}
+
+	s := strconv.FormatInt(n, 10)
+	return &dynamodb.AttributeValue{S: aws.String(strings.Repeat("0", sortKeyLen-len(s)) + s)}, nil
+}
+
+// the value of a String or Number sort key attribute
+func sortKeyString(v *dynamodb.AttributeValue) string {
+	if v.N != nil {
+		return *v.N
+	}
+	return aws.StringValue(v.S)
+}
+
+// versionQuery returns a query for the primary key attributes of all versions of the secret, newest first
+func (b *DynamoDbBackend) versionQuery(key string) *dynamodb.QueryInput {
+	return &dynamodb.QueryInput{
+		TableName:                aws.String(b.table),
+		KeyConditionExpression:   aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(b.pk), "#sk": aws.String(b.sk)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk": {S: aws.String(key)},
+		},
+		ProjectionExpression: aws.String("#pk, #sk"),
+		ScanIndexForward:     aws.Bool(false),
+		ConsistentRead:       aws.Bool(true),
+	}
+}
+
+// getItem returns the item for the secret, or the latest version of the secret for tables with a sort key
+func (b *DynamoDbBackend) getItem(key string) (map[string]*dynamodb.AttributeValue, error) {
+	if len(b.sk) > 0 {
+		i := b.versionQuery(key)
+		i.Limit = aws.Int64(1)
+		i.ProjectionExpression = nil
+		delete(i.ExpressionAttributeNames, "#sk")
+
+		o, err := b.c.Query(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(o.Items) < 1 {
+			return nil, ErrSecretNotFound
+		}
+		return o.Items[0], nil
+	}
+
+	i := dynamodb.GetItemInput{
+		TableName:      aws.String(b.table),
+		Key:            map[string]*dynamodb.AttributeValue{b.pk: {S: aws.String(key)}},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	o, err := b.c.GetItem(&i)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(o.Item) < 1 {
+		return nil, ErrSecretNotFound
+	}
+	return o.Item, nil
+}
+
+// Fetch retrieves the item from the table using the Partition key defined in the key parameter,
+// and returns the KMS decrypted data from the "value" attribute.  For tables with a sort key, the
+// latest version of the item is used.
+func (b *DynamoDbBackend) Fetch(key string) ([]byte, error) {
+	if b.credstash {
+		return b.credstashFetch(key)
+	}
+
+	log.Debugf("reading key %s from DynamoDB table %s", key, b.table)
+	item, err := b.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := item["value"]
+	if !ok || v.S == nil {
+		return nil, fmt.Errorf("item %s is missing the value attribute", key)
+	}
+
+	// items written without an encryption context will not have the attribute
+	var ctx map[string]string
+	if c, ok := item[contextAttr]; ok && c.M != nil {
+		ctx = make(map[string]string, len(c.M))
+		for k, v := range c.M {
+			if v.S == nil {
+				return nil, fmt.Errorf("item %s has an invalid %s attribute", key, contextAttr)
+			}
+			ctx[k] = *v.S
+		}
+	}
+
+	// items written using envelope encryption hold the encrypted data key
+	if dk, ok := item[dataKeyAttr]; ok && dk.S != nil {
+		n, ok := item[nonceAttr]
+		if !ok || n.S == nil {
+			return nil, fmt.Errorf("item %s is missing the %s attribute", key, nonceAttr)
+		}
+		return b.envelopeDecrypt(key, *v.S, *dk.S, *n.S, ctx)
+	}
+
+	return b.decrypt(*v.S, ctx)
+}
+
+// encryptionContext returns the KMS encryption context for the key, or nil if no context is configured.
+// The automatic context values take precedence over static context values using the same context keys.
+func (b *DynamoDbBackend) encryptionContext(key string) map[string]string {
+	if len(b.context) < 1 && !b.autoContext {
+		return nil
+	}
+
+	ctx := make(map[string]string, len(b.context)+2)
+	for k, v := range b.context {
+		ctx[k] = v
+	}
+
+	if b.autoContext {
+		ctx["table"] = b.table
+		ctx["key"] = key
+	}
+	return ctx
+}
+
 // max size of value is 4096 bytes due to max size of KMS encrypt operation input
-func (b *DynamoDbBackend) encrypt(value interface{}) (string, error) {
+func (b *DynamoDbBackend) encrypt(value interface{}, kmsKey string, ctx map[string]string) (string, error) {
 	r, err := readBinary(value)
 	if err != nil {
 		return "", err
@@ -101,7 +434,11 @@ func (b *DynamoDbBackend) encrypt(value interface{}) (string, error) {
 		return "", err
 	}
 
-	i := kms.EncryptInput{KeyId: aws.String(keyArn.String()), Plaintext: data}
+	i := kms.EncryptInput{KeyId: aws.String(kmsKey), Plaintext: data}
+	if len(ctx) > 0 {
+		i.EncryptionContext = aws.StringMap(ctx)
+	}
+
 	o, err := b.k.Encrypt(&i)
 	if err != nil {
 		return "", err
@@ -111,3 +448,195 @@ func (b *DynamoDbBackend) encrypt(value interface{}) (string, error) {
 	// Encrypt API call returns bytes, encode to base64 and return
 	return base64.StdEncoding.EncodeToString(o.CiphertextBlob), nil
 }
+
+// the KMS ciphertext blob contains the key information, so there's no need to supply the key ID, however
+// the encryption context must match the context used to encrypt the value
+func (b *DynamoDbBackend) decrypt(value string, ctx map[string]string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	i := kms.DecryptInput{CiphertextBlob: data}
+	if len(ctx) > 0 {
+		i.EncryptionContext = aws.StringMap(ctx)
+	}
+
+	o, err := b.k.Decrypt(&i)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("successfully decrypted data")
+
+	return o.Plaintext, nil
+}
+
+// envelopeEncrypt encrypts the value using AES-256-GCM with a new KMS data key, using the item key as the additional
+// authenticated data so the ciphertext can not be moved to another item, and returns the base64 encoded ciphertext,
+// encrypted data key, and nonce
+func (b *DynamoDbBackend) envelopeEncrypt(key string, value interface{}, kmsKey string, ctx map[string]string) (string, string, string, error) {
+	r, err := readBinary(value)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if len(data) < 1 {
+		return "", "", "", fmt.Errorf("empty value")
+	}
+
+	i := kms.GenerateDataKeyInput{KeyId: aws.String(kmsKey), KeySpec: aws.String(kms.DataKeySpecAes256)}
+	if len(ctx) > 0 {
+		i.EncryptionContext = aws.StringMap(ctx)
+	}
+
+	o, err := b.k.GenerateDataKey(&i)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer zero(o.Plaintext)
+
+	gcm, err := newGCM(o.Plaintext)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", "", err
+	}
+	log.Debugf("successfully encrypted data using envelope encryption")
+
+	enc := base64.StdEncoding
+	return enc.EncodeToString(gcm.Seal(nil, nonce, data, []byte(key))), enc.EncodeToString(o.CiphertextBlob), enc.EncodeToString(nonce), nil
+}
+
+// envelopeDecrypt decrypts the data key using KMS, then decrypts the value locally
+func (b *DynamoDbBackend) envelopeDecrypt(key, value, dataKey, nonce string, ctx map[string]string) ([]byte, error) {
+	dk, err := b.decrypt(dataKey, ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(dk)
+
+	gcm, err := newGCM(dk)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(n) != gcm.NonceSize() {
+		return nil, fmt.Errorf("item %s has an invalid %s attribute", key, nonceAttr)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, n, data, []byte(key))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(c)
+}
+
+// overwrite the plaintext data key, so it doesn't linger in memory any longer than needed
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// List returns the Partition key values of all items in the table which start with the provided prefix.
+// This is done using a Scan operation, so the entire table is read to find the matching items.  Each key
+// is only returned once, regardless of the number of versions.
+func (b *DynamoDbBackend) List(prefix string) ([]string, error) {
+	i := dynamodb.ScanInput{
+		TableName:                aws.String(b.table),
+		ProjectionExpression:     aws.String("#pk"),
+		FilterExpression:         aws.String("begins_with(#pk, :prefix)"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(b.pk)},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(prefix)},
+		},
+	}
+
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	err := b.c.ScanPages(&i, func(o *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range o.Items {
+			if v, ok := item[b.pk]; ok && v.S != nil && !seen[*v.S] {
+				seen[*v.S] = true
+				keys = append(keys, *v.S)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete removes the item from the table using the Partition key defined in the key parameter.
+// For tables with a sort key, all versions of the item are removed.
+func (b *DynamoDbBackend) Delete(key string) error {
+	if len(b.sk) > 0 {
+		return b.deleteVersions(key)
+	}
+
+	i := dynamodb.DeleteItemInput{
+		TableName: aws.String(b.table),
+		Key:       map[string]*dynamodb.AttributeValue{b.pk: {S: aws.String(key)}},
+	}
+
+	log.Debugf("deleting key %s from DynamoDB table %s", key, b.table)
+	_, err := b.c.DeleteItem(&i)
+	return err
+}
+
+// deleteVersions removes all versions of the item, returning ErrSecretNotFound if there are none
+func (b *DynamoDbBackend) deleteVersions(key string) error {
+	versions := make([]*dynamodb.AttributeValue, 0)
+	err := b.c.QueryPages(b.versionQuery(key), func(o *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range o.Items {
+			versions = append(versions, item[b.sk])
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(versions) < 1 {
+		return ErrSecretNotFound
+	}
+
+	for _, v := range versions {
+		i := dynamodb.DeleteItemInput{
+			TableName: aws.String(b.table),
+			Key:       map[string]*dynamodb.AttributeValue{b.pk: {S: aws.String(key)}, b.sk: v},
+		}
+
+		log.Debugf("deleting key %s version %s from DynamoDB table %s", key, sortKeyString(v), b.table)
+		if _, err := b.c.DeleteItem(&i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
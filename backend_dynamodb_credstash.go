@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// the credstash table key schema, and item attributes.  The version sort key is a String, so the versions
+// are zero-padded to 19 digits as credstash does.
+const (
+	credstashName     = "name"
+	credstashVersion  = "version"
+	credstashKey      = "key"
+	credstashContents = "contents"
+	credstashHmac     = "hmac"
+	credstashDigest   = "digest"
+)
+
+// credstash uses a fixed counter block for AES-CTR, since each value is encrypted using a new data key
+var credstashNonce = append(make([]byte, aes.BlockSize-1), 1)
+
+// credstashPut writes the value as the next version of the credstash secret, using the credstash envelope encryption
+// scheme.  A 64 byte data key is generated by KMS, the first half is used to encrypt the value using AES-256-CTR,
+// and the second half is used to create the HMAC-SHA256 of the ciphertext.
+func (b *DynamoDbBackend) credstashPut(key string, value interface{}, kmsKey string, ctx map[string]string) error {
+	r, err := readBinary(value)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 1 {
+		return fmt.Errorf("empty value")
+	}
+
+	ver, err := b.nextSortKey(key)
+	if err != nil {
+		return err
+	}
+
+	i := kms.GenerateDataKeyInput{KeyId: aws.String(kmsKey), NumberOfBytes: aws.Int64(64)}
+	if len(ctx) > 0 {
+		i.EncryptionContext = aws.StringMap(ctx)
+	}
+
+	o, err := b.k.GenerateDataKey(&i)
+	if err != nil {
+		return err
+	}
+	defer zero(o.Plaintext)
+
+	contents, mac, err := credstashSeal(o.Plaintext, data)
+	if err != nil {
+		return err
+	}
+
+	p := dynamodb.PutItemInput{
+		TableName: aws.String(b.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			credstashName:     {S: aws.String(key)},
+			credstashVersion:  ver,
+			credstashKey:      {S: aws.String(base64.StdEncoding.EncodeToString(o.CiphertextBlob))},
+			credstashContents: {S: aws.String(base64.StdEncoding.EncodeToString(contents))},
+			credstashHmac:     {B: []byte(hex.EncodeToString(mac))},
+			credstashDigest:   {S: aws.String("SHA256")},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#pk)"),
+		ExpressionAttributeNames: map[string]*string{"#pk": aws.String(credstashName)},
+	}
+
+	log.Debugf("writing credstash secret %s version %s in DynamoDB table %s", key, *ver.S, b.table)
+	if _, err := b.c.PutItem(&p); err != nil {
+		return b.putError(key, p.Item, err)
+	}
+
+	return nil
+}
+
+// credstashFetch returns the decrypted value of the latest version of the credstash secret, after verifying the HMAC
+func (b *DynamoDbBackend) credstashFetch(key string) ([]byte, error) {
+	log.Debugf("reading credstash secret %s from DynamoDB table %s", key, b.table)
+	item, err := b.getItem(key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range []string{credstashKey, credstashContents} {
+		if v, ok := item[a]; !ok || v.S == nil {
+			return nil, fmt.Errorf("item %s is missing the %s attribute", key, a)
+		}
+	}
+
+	if v, ok := item[credstashHmac]; !ok || (v.S == nil && v.B == nil) {
+		return nil, fmt.Errorf("item %s is missing the %s attribute", key, credstashHmac)
+	}
+
+	if d, ok := item[credstashDigest]; ok && d.S != nil && *d.S != "SHA256" {
+		return nil, fmt.Errorf("unsupported credstash digest %s for %s", *d.S, key)
+	}
+
+	// the encryption context is not stored by credstash, so the backend context must match the writer's context
+	dk, err := b.decrypt(*item[credstashKey].S, b.encryptionContext(key))
+	if err != nil {
+		return nil, err
+	}
+	defer zero(dk)
+
+	contents, err := base64.StdEncoding.DecodeString(*item[credstashContents].S)
+	if err != nil {
+		return nil, err
+	}
+
+	// older credstash versions store the hex encoded hmac as a string, newer versions as binary
+	h := item[credstashHmac].B
+	if item[credstashHmac].S != nil {
+		h = []byte(*item[credstashHmac].S)
+	}
+
+	mac, err := hex.DecodeString(string(h))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hmac for %s: %v", key, err)
+	}
+
+	return credstashOpen(dk, contents, mac)
+}
+
+// credstashSeal encrypts the data with the first half of the 64 byte key, returning the ciphertext and the
+// HMAC-SHA256 of the ciphertext using the second half of the key
+func credstashSeal(key, data []byte) ([]byte, []byte, error) {
+	if len(key) != 64 {
+		return nil, nil, fmt.Errorf("invalid credstash key length %d", len(key))
+	}
+
+	c, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCTR(c, credstashNonce).XORKeyStream(out, data)
+
+	h := hmac.New(sha256.New, key[32:])
+	h.Write(out)
+
+	return out, h.Sum(nil), nil
+}
+
+// credstashOpen verifies the HMAC of the ciphertext, and returns the decrypted data
+func credstashOpen(key, data, mac []byte) ([]byte, error) {
+	if len(key) != 64 {
+		return nil, fmt.Errorf("invalid credstash key length %d", len(key))
+	}
+
+	h := hmac.New(sha256.New, key[32:])
+	h.Write(data)
+	if !hmac.Equal(mac, h.Sum(nil)) {
+		return nil, fmt.Errorf("credstash hmac verification failed")
+	}
+
+	c, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCTR(c, credstashNonce).XORKeyStream(out, data)
+
+	return out, nil
+}
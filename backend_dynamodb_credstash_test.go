@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func newCredstashBackend() (*DynamoDbBackend, *mockVersionedDynamoDBClient) {
+	c := &mockVersionedDynamoDBClient{pk: credstashName, sk: credstashVersion}
+	d := NewDynamoDbBackend().WithCredstash(true)
+	d.c = c
+	d.k = new(mockKmsClient)
+	d.table = "credential-store"
+	d.pk = credstashName
+	d.sk = credstashVersion
+	d.skType = dynamodb.ScalarAttributeTypeS
+	d.setKmsKey("alias/credstash")
+	return d, c
+}
+
+func TestCredstashSeal(t *testing.T) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+
+	// generated using openssl enc -aes-256-ctr and openssl dgst -sha256 -mac HMAC
+	wantData := "98381ac22599fc97c392e84529b15e"
+	wantMac := "dde14cab70d9e13e47616da0dd9c7acdb76895a31e3cda45ccf7f833b35b4a13"
+
+	data, mac, err := credstashSeal(key, []byte("hello credstash"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if hex.EncodeToString(data) != wantData || hex.EncodeToString(mac) != wantMac {
+		t.Errorf("unexpected ciphertext %x or hmac %x", data, mac)
+		return
+	}
+
+	t.Run("open", func(t *testing.T) {
+		v, err := credstashOpen(key, data, mac)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "hello credstash" {
+			t.Errorf("data mismatch: %s", v)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		bad := append([]byte{}, data...)
+		bad[0] ^= 1
+
+		if _, err := credstashOpen(key, bad, mac); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad key", func(t *testing.T) {
+		if _, _, err := credstashSeal(key[:32], []byte("x")); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDynamoDbBackend_Credstash(t *testing.T) {
+	d, c := newCredstashBackend()
+
+	t.Run("store", func(t *testing.T) {
+		if err := d.Store("app.password", "first"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		item := c.items["app.password"]["0000000000000000001"]
+		if item == nil {
+			t.Errorf("version 1 not written: %v", c.versions("app.password"))
+			return
+		}
+
+		for _, a := range []string{credstashKey, credstashContents, credstashDigest} {
+			if item[a] == nil || item[a].S == nil {
+				t.Errorf("missing %s attribute", a)
+			}
+		}
+
+		if _, err := hex.DecodeString(string(item[credstashHmac].B)); err != nil {
+			t.Errorf("hmac is not hex encoded: %v", err)
+		}
+	})
+
+	t.Run("new version", func(t *testing.T) {
+		if err := d.Store("app.password", "second"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if v := c.versions("app.password"); len(v) != 2 || v[0] != "0000000000000000002" {
+			t.Errorf("unexpected versions: %v", v)
+			return
+		}
+
+		v, err := d.Fetch("app.password")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "second" {
+			t.Errorf("did not fetch the latest version: %s", v)
+		}
+	})
+
+	t.Run("string hmac", func(t *testing.T) {
+		item := c.items["app.password"]["0000000000000000002"]
+		item[credstashHmac] = &dynamodb.AttributeValue{S: aws.String(string(item[credstashHmac].B))}
+
+		if v, err := d.Fetch("app.password"); err != nil || string(v) != "second" {
+			t.Errorf("unexpected fetch result %s: %v", v, err)
+		}
+	})
+
+	t.Run("bad hmac", func(t *testing.T) {
+		if err := d.Store("app.token", "value"); err != nil {
+			t.Error(err)
+			return
+		}
+		c.items["app.token"]["0000000000000000001"][credstashHmac] = &dynamodb.AttributeValue{B: []byte(strings.Repeat("0", 64))}
+
+		if _, err := d.Fetch("app.token"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := d.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := d.List("app.")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sort.Strings(keys)
+
+		if strings.Join(keys, ",") != "app.password,app.token" {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := d.Delete("app.password"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, ok := c.items["app.password"]; ok {
+			t.Errorf("versions not deleted: %v", c.versions("app.password"))
+		}
+
+		if err := d.Delete("app.password"); err != ErrSecretNotFound {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestDynamoDbBackend_CredstashContext(t *testing.T) {
+	d, _ := newCredstashBackend()
+	d.WithEncryptionContext(map[string]string{"app": "web"})
+
+	if err := d.Store("key", "value"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v, err := d.Fetch("key"); err != nil || string(v) != "value" {
+		t.Errorf("unexpected fetch result %s: %v", v, err)
+		return
+	}
+
+	d.WithEncryptionContext(map[string]string{"app": "api"})
+	if _, err := d.Fetch("key"); err == nil {
+		t.Error("did not receive expected error")
+	}
+}
@@ -1,18 +1,47 @@
 package main
 
 import (
+	"crypto/rand"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type mockKmsClient struct {
 	kmsiface.KMSAPI
+	lastKey   string
+	describes int
+	contexts  map[string]string // the encryption context used for each ciphertext
+}
+
+func contextString(c map[string]*string) string {
+	return mapValue(aws.StringValueMap(c)).String()
+}
+
+func (m *mockKmsClient) DescribeKey(input *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	m.describes++
+
+	id := strings.TrimPrefix(*input.KeyId, "alias/")
+	if id == "missing" {
+		return nil, awserr.New(kms.ErrCodeNotFoundException, "key not found", nil)
+	}
+
+	keyArn := id
+	if !strings.HasPrefix(id, "arn:") {
+		keyArn = "arn:aws:kms:us-east-1:012345678901:key/" + id
+	}
+
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{Arn: aws.String(keyArn)}}, nil
 }
 
 func (m *mockKmsClient) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, error) {
@@ -20,13 +49,58 @@ func (m *mockKmsClient) Encrypt(input *kms.EncryptInput) (*kms.EncryptOutput, er
 		return nil, fmt.Errorf("plaintext min length is 1")
 	}
 
+	m.lastKey = aws.StringValue(input.KeyId)
+
+	if m.contexts == nil {
+		m.contexts = make(map[string]string)
+	}
+	m.contexts[string(input.Plaintext)] = contextString(input.EncryptionContext)
+
 	o := new(kms.EncryptOutput)
 	o.CiphertextBlob = input.Plaintext
 	return o, nil
 }
 
+func (m *mockKmsClient) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	size := aws.Int64Value(input.NumberOfBytes)
+	if aws.StringValue(input.KeySpec) == kms.DataKeySpecAes256 {
+		size = 32
+	}
+
+	if size < 1 {
+		return nil, fmt.Errorf("unexpected key spec")
+	}
+	m.lastKey = aws.StringValue(input.KeyId)
+
+	// like Encrypt, the mock ciphertext is the plaintext
+	k := make([]byte, size)
+	rand.Read(k)
+
+	if m.contexts == nil {
+		m.contexts = make(map[string]string)
+	}
+	m.contexts[string(k)] = contextString(input.EncryptionContext)
+
+	return &kms.GenerateDataKeyOutput{Plaintext: k, CiphertextBlob: append([]byte{}, k...)}, nil
+}
+
+func (m *mockKmsClient) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	if input.CiphertextBlob == nil || len(input.CiphertextBlob) < 1 {
+		return nil, fmt.Errorf("ciphertext min length is 1")
+	}
+
+	if c, ok := m.contexts[string(input.CiphertextBlob)]; ok && c != contextString(input.EncryptionContext) {
+		return nil, awserr.New(kms.ErrCodeInvalidCiphertextException, "encryption context mismatch", nil)
+	}
+
+	o := new(kms.DecryptOutput)
+	o.Plaintext = input.CiphertextBlob
+	return o, nil
+}
+
 type mockDynamoDBClient struct {
 	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
 }
 
 func (m *mockDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
@@ -44,6 +118,22 @@ func (m *mockDynamoDBClient) DescribeTable(input *dynamodb.DescribeTableInput) (
 		return o, nil
 	}
 
+	if t := strings.TrimPrefix(*input.TableName, "versioned-"); t != *input.TableName {
+		o := new(dynamodb.DescribeTableOutput)
+		o.Table = &dynamodb.TableDescription{
+			KeySchema: []*dynamodb.KeySchemaElement{
+				{KeyType: aws.String(dynamodb.KeyTypeHash), AttributeName: aws.String("key")},
+				{KeyType: aws.String(dynamodb.KeyTypeRange), AttributeName: aws.String("version")},
+			},
+			AttributeDefinitions: []*dynamodb.AttributeDefinition{
+				{AttributeName: aws.String("key"), AttributeType: aws.String(dynamodb.ScalarAttributeTypeS)},
+				{AttributeName: aws.String("version"), AttributeType: aws.String(t)},
+			},
+		}
+
+		return o, nil
+	}
+
 	return nil, fmt.Errorf(dynamodb.ErrCodeTableNotFoundException)
 }
 
@@ -57,9 +147,134 @@ func (m *mockDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.Pu
 			return nil, fmt.Errorf("empty value")
 		}
 	}
+
+	if m.items == nil {
+		m.items = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+	m.items[*input.Item["key"].S] = input.Item
+
 	return nil, nil
 }
 
+func (m *mockDynamoDBClient) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[*input.Key["key"].S]}, nil
+}
+
+func (m *mockDynamoDBClient) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	prefix := *input.ExpressionAttributeValues[":prefix"].S
+
+	o := new(dynamodb.ScanOutput)
+	for k := range m.items {
+		if strings.HasPrefix(k, prefix) {
+			o.Items = append(o.Items, map[string]*dynamodb.AttributeValue{"key": {S: aws.String(k)}})
+		}
+	}
+	fn(o, true)
+	return nil
+}
+
+func (m *mockDynamoDBClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.items, *input.Key["key"].S)
+	return new(dynamodb.DeleteItemOutput), nil
+}
+
+// mockVersionedDynamoDBClient stores items in a table using the pk partition key and sk sort key
+type mockVersionedDynamoDBClient struct {
+	dynamodbiface.DynamoDBAPI
+	pk    string
+	sk    string
+	items map[string]map[string]map[string]*dynamodb.AttributeValue
+}
+
+// the sort key values of the item, newest first
+func (m *mockVersionedDynamoDBClient) versions(key string) []string {
+	v := make([]string, 0)
+	for k := range m.items[key] {
+		v = append(v, k)
+	}
+
+	sort.Slice(v, func(i, j int) bool {
+		a, _ := strconv.ParseInt(v[i], 10, 64)
+		b, _ := strconv.ParseInt(v[j], 10, 64)
+		return a > b
+	})
+	return v
+}
+
+func (m *mockVersionedDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	key := *input.Item[m.pk].S
+	ver := sortKeyString(input.Item[m.sk])
+
+	if m.items == nil {
+		m.items = make(map[string]map[string]map[string]*dynamodb.AttributeValue)
+	}
+
+	if m.items[key] == nil {
+		m.items[key] = make(map[string]map[string]*dynamodb.AttributeValue)
+	}
+
+	if _, ok := m.items[key][ver]; ok && input.ConditionExpression != nil {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional check failed", nil)
+	}
+	m.items[key][ver] = input.Item
+
+	return new(dynamodb.PutItemOutput), nil
+}
+
+func (m *mockVersionedDynamoDBClient) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	for k := range input.ExpressionAttributeNames {
+		if !strings.Contains(aws.StringValue(input.KeyConditionExpression)+aws.StringValue(input.ProjectionExpression), k) {
+			return nil, fmt.Errorf("unused expression attribute name %s", k)
+		}
+	}
+
+	key := *input.ExpressionAttributeValues[":pk"].S
+
+	o := new(dynamodb.QueryOutput)
+	for _, v := range m.versions(key) {
+		if input.Limit != nil && int64(len(o.Items)) >= *input.Limit {
+			break
+		}
+		o.Items = append(o.Items, m.items[key][v])
+	}
+	return o, nil
+}
+
+func (m *mockVersionedDynamoDBClient) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	o, err := m.Query(input)
+	if err != nil {
+		return err
+	}
+
+	fn(o, true)
+	return nil
+}
+
+func (m *mockVersionedDynamoDBClient) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	prefix := *input.ExpressionAttributeValues[":prefix"].S
+
+	o := new(dynamodb.ScanOutput)
+	for key := range m.items {
+		for range m.items[key] {
+			if strings.HasPrefix(key, prefix) {
+				o.Items = append(o.Items, map[string]*dynamodb.AttributeValue{m.pk: {S: aws.String(key)}})
+			}
+		}
+	}
+	fn(o, true)
+	return nil
+}
+
+func (m *mockVersionedDynamoDBClient) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	key := *input.Key[m.pk].S
+
+	delete(m.items[key], sortKeyString(input.Key[m.sk]))
+	if len(m.items[key]) < 1 {
+		delete(m.items, key)
+	}
+	return new(dynamodb.DeleteItemOutput), nil
+}
+
 func TestNewDynamoDbBackend(t *testing.T) {
 	t.Run("nil session", func(t *testing.T) {
 		d := NewDynamoDbBackend()
@@ -111,6 +326,26 @@ func TestDynamoDbBackend_WithTable(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("sort key", func(t *testing.T) {
+		d, err := d.WithTable("versioned-N")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if d.pk != "key" || d.sk != "version" || d.skType != dynamodb.ScalarAttributeTypeN {
+			t.Errorf("unexpected key schema: %s, %s (%s)", d.pk, d.sk, d.skType)
+			return
+		}
+	})
+
+	t.Run("binary sort key", func(t *testing.T) {
+		if _, err := d.WithTable("versioned-B"); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
 }
 
 func TestDynamoDbBackend_Store(t *testing.T) {
@@ -170,3 +405,408 @@ func TestDynamoDbBackend_Store(t *testing.T) {
 		}
 	})
 }
+
+func TestDynamoDbBackend_StoreRecord(t *testing.T) {
+	k := new(mockKmsClient)
+
+	d := NewDynamoDbBackend()
+	d.c = new(mockDynamoDBClient)
+	d.k = k
+	d.table = "my-table"
+	d.pk = "key"
+	d.setKmsKey("backend-key")
+
+	t.Run("backend key", func(t *testing.T) {
+		if err := d.storeRecord(&secret{key: "a", value: "v", description: "ignored"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if k.lastKey != "backend-key" {
+			t.Errorf("unexpected KMS key: %s", k.lastKey)
+		}
+	})
+
+	t.Run("key map", func(t *testing.T) {
+		d.setKmsKeyMap(map[string]string{"/team-a/": "team-a-key", "/team-a/shared/": "shared-key"})
+		defer d.setKmsKeyMap(nil)
+
+		if err := d.Store("/team-a/shared/x", "v"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if k.lastKey != "shared-key" {
+			t.Errorf("unexpected KMS key: %s", k.lastKey)
+		}
+	})
+
+	t.Run("record key", func(t *testing.T) {
+		d.setKmsKeyMap(map[string]string{"b": "team-b-key"})
+		defer d.setKmsKeyMap(nil)
+
+		if err := d.storeRecord(&secret{key: "b", value: "v", kmsKey: "alias/record"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if k.lastKey != "alias/record" {
+			t.Errorf("unexpected KMS key: %s", k.lastKey)
+		}
+	})
+}
+
+func TestDynamoDbBackend_EncryptionContext(t *testing.T) {
+	newBackend := func() (*DynamoDbBackend, *mockDynamoDBClient, *mockKmsClient) {
+		c := new(mockDynamoDBClient)
+		k := new(mockKmsClient)
+
+		d := NewDynamoDbBackend()
+		d.c = c
+		d.k = k
+		d.table = "my-table"
+		d.pk = "key"
+		return d, c, k
+	}
+
+	t.Run("none", func(t *testing.T) {
+		d, c, _ := newBackend()
+		if err := d.Store("a", "plain"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, ok := c.items["a"][contextAttr]; ok {
+			t.Error("unexpected context attribute")
+		}
+	})
+
+	t.Run("static and auto", func(t *testing.T) {
+		d, c, k := newBackend()
+		d.WithEncryptionContext(map[string]string{"app": "web", "key": "overridden"}).WithAutoContext(true)
+
+		if err := d.Store("/app/a", "with context"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if ctx := k.contexts["with context"]; ctx != "app=web,key=/app/a,table=my-table" {
+			t.Errorf("unexpected encryption context: %s", ctx)
+		}
+
+		if attr := c.items["/app/a"][contextAttr]; attr == nil || len(attr.M) != 3 || *attr.M["table"].S != "my-table" {
+			t.Errorf("unexpected context attribute: %v", attr)
+		}
+
+		v, err := d.Fetch("/app/a")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "with context" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("mismatch", func(t *testing.T) {
+		d, c, _ := newBackend()
+		d.WithAutoContext(true)
+
+		if err := d.Store("/app/a", "moved"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// simulate an item copied to another key, which must not decrypt
+		c.items["/app/a"][contextAttr].M["key"].S = aws.String("/app/b")
+		if _, err := d.Fetch("/app/a"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDynamoDbBackend_Envelope(t *testing.T) {
+	c := new(mockDynamoDBClient)
+	k := new(mockKmsClient)
+
+	d := NewDynamoDbBackend().WithEnvelope(true).WithAutoContext(true)
+	d.c = c
+	d.k = k
+	d.table = "my-table"
+	d.pk = "key"
+	d.setKmsKey("backend-key")
+
+	large := strings.Repeat("0123456789", 1000)
+
+	t.Run("store", func(t *testing.T) {
+		if err := d.Store("/app/cert", large); err != nil {
+			t.Error(err)
+			return
+		}
+
+		item := c.items["/app/cert"]
+		if item[dataKeyAttr] == nil || item[nonceAttr] == nil || *item["value"].S == large {
+			t.Errorf("unexpected item: %v", item)
+		}
+
+		if k.lastKey != "backend-key" {
+			t.Errorf("unexpected KMS key: %s", k.lastKey)
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		v, err := d.Fetch("/app/cert")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != large {
+			t.Error("unexpected value")
+		}
+	})
+
+	t.Run("moved item", func(t *testing.T) {
+		// the item key is the additional authenticated data, so the ciphertext is only valid for the original key
+		item := c.items["/app/cert"]
+		c.items["/app/other"] = item
+		item[contextAttr].M["key"].S = aws.String("/app/other")
+		defer func() { item[contextAttr].M["key"].S = aws.String("/app/cert") }()
+
+		k.contexts = nil
+		if _, err := d.Fetch("/app/other"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("empty value", func(t *testing.T) {
+		if err := d.Store("/app/empty", ""); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("legacy item", func(t *testing.T) {
+		// items written without envelope encryption remain readable
+		l := NewDynamoDbBackend()
+		l.c = c
+		l.k = k
+		l.table = "my-table"
+		l.pk = "key"
+
+		if err := l.Store("/app/legacy", "old value"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		v, err := d.Fetch("/app/legacy")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "old value" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+}
+
+func TestDynamoDbBackend_Fetch(t *testing.T) {
+	d := NewDynamoDbBackend()
+	d.c = new(mockDynamoDBClient)
+	d.k = new(mockKmsClient)
+	d.table = "my-table"
+	d.pk = "key"
+
+	if err := d.Store("my-key", "a value"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		v, err := d.Fetch("my-key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "a value" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := d.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
+func TestDynamoDbBackend_Versioned(t *testing.T) {
+	newBackend := func(skType, skValue string) (*DynamoDbBackend, *mockVersionedDynamoDBClient) {
+		c := &mockVersionedDynamoDBClient{pk: "key", sk: "version"}
+		d := NewDynamoDbBackend()
+		d.c = c
+		d.k = new(mockKmsClient)
+		d.table = "my-table"
+		d.pk = "key"
+		d.sk = "version"
+		d.skType = skType
+		return d.WithSortKeyValue(skValue), c
+	}
+
+	t.Run("version", func(t *testing.T) {
+		d, c := newBackend(dynamodb.ScalarAttributeTypeN, sortKeyVersion)
+
+		for _, v := range []string{"first", "second", "third"} {
+			if err := d.Store("/app/key", v); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if v := c.versions("/app/key"); strings.Join(v, ",") != "3,2,1" {
+			t.Errorf("unexpected versions: %v", v)
+			return
+		}
+
+		v, err := d.Fetch("/app/key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "third" {
+			t.Errorf("did not fetch the latest version: %s", v)
+		}
+	})
+
+	t.Run("string version", func(t *testing.T) {
+		d, c := newBackend(dynamodb.ScalarAttributeTypeS, "")
+
+		for i := 0; i < 10; i++ {
+			if err := d.Store("/app/key", strconv.Itoa(i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if v := c.versions("/app/key"); v[0] != "0000000000000000010" {
+			t.Errorf("unexpected latest version: %s", v[0])
+			return
+		}
+
+		if v, err := d.Fetch("/app/key"); err != nil || string(v) != "9" {
+			t.Errorf("unexpected fetch result %s: %v", v, err)
+		}
+	})
+
+	t.Run("timestamp", func(t *testing.T) {
+		d, c := newBackend(dynamodb.ScalarAttributeTypeN, sortKeyTimestamp)
+
+		start := time.Now().UnixNano()
+		if err := d.Store("/app/key", "value"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		v, _ := strconv.ParseInt(c.versions("/app/key")[0], 10, 64)
+		if v < start || v > time.Now().UnixNano() {
+			t.Errorf("unexpected timestamp: %d", v)
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		d, c := newBackend(dynamodb.ScalarAttributeTypeN, sortKeyVersion)
+		if err := d.Store("/app/key", "first"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// another writer stored version 2 between our version query and write
+		d.c = &racingDynamoDBClient{c, "/app/key", "2"}
+		if err := d.Store("/app/key", "second"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("list delete", func(t *testing.T) {
+		d, c := newBackend(dynamodb.ScalarAttributeTypeN, sortKeyVersion)
+		for _, k := range []string{"/app/a", "/app/a", "/app/b", "/other"} {
+			if err := d.Store(k, "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys, err := d.List("/app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(keys) != 2 {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+
+		if err := d.Delete("/app/a"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, ok := c.items["/app/a"]; ok {
+			t.Errorf("versions not deleted: %v", c.versions("/app/a"))
+		}
+
+		if _, err := d.Fetch("/app/a"); err != ErrSecretNotFound {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+// racingDynamoDBClient writes a version of the key before passing the PutItem call to the wrapped client
+type racingDynamoDBClient struct {
+	*mockVersionedDynamoDBClient
+	key     string
+	version string
+}
+
+func (m *racingDynamoDBClient) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.items[m.key][m.version] = map[string]*dynamodb.AttributeValue{}
+	return m.mockVersionedDynamoDBClient.PutItem(input)
+}
+
+func TestDynamoDbBackend_ListDelete(t *testing.T) {
+	d := NewDynamoDbBackend()
+	d.c = new(mockDynamoDBClient)
+	d.k = new(mockKmsClient)
+	d.table = "my-table"
+	d.pk = "key"
+
+	for _, k := range []string{"/app/a", "/app/b", "/other"} {
+		if err := d.Store(k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := d.List("/app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(keys) != 2 {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := d.Delete("/app/a"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := d.Fetch("/app/a"); err != ErrSecretNotFound {
+			t.Errorf("item was not deleted: %v", err)
+		}
+	})
+}
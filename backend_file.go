@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FileBackend is the type for storing AES-GCM encrypted values in a local json file.  It is intended for
+// offline development and testing, where AWS credentials may not be available.
+type FileBackend struct {
+	kmsRequired bool
+	path        string
+	key         []byte
+	salt        []byte
+	mu          sync.Mutex
+}
+
+// fileStore is the on-disk format of the store file.  The salt is only used when the encryption key is
+// derived from a passphrase, and the secrets map values are the base64 encoded nonce and ciphertext.
+type fileStore struct {
+	Salt    []byte            `json:"salt"`
+	Secrets map[string]string `json:"secrets"`
+}
+
+// NewFileBackend creates a basic local file SecretsBackender.  Note that the store file and the
+// encryption key are not defined with this call, see WithFile() and WithKeyFile() or WithPassphrase()
+// to set those before making any calls to Store()
+func NewFileBackend() *FileBackend {
+	return &FileBackend{kmsRequired: false}
+}
+
+// WithFile sets the path of the json file used to store the encrypted values.  The file will be
+// created on the first call to Store() if it does not exist.
+func (b *FileBackend) WithFile(path string) *FileBackend {
+	b.path = path
+	return b
+}
+
+// WithKeyFile reads the AES-256 encryption key from the provided file.  The file must contain exactly
+// 32 bytes of key data, either as raw bytes or base64 encoded.
+func (b *FileBackend) WithKeyFile(path string) (*FileBackend, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key file: %v", err)
+	}
+
+	if len(data) != 32 {
+		data, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(data) != 32 {
+			return nil, fmt.Errorf("key file %s must contain a 32 byte key, or a base64 encoded 32 byte key", path)
+		}
+	}
+
+	b.key = data
+	return b, nil
+}
+
+// WithPassphrase derives the AES-256 encryption key from the passphrase using scrypt.  The salt is
+// persisted in the store file, so WithFile() must be called before calling this method.  A new salt is
+// only written to the store file by the first call to Store(), so modes which only read have no side effects.
+func (b *FileBackend) WithPassphrase(p string) (*FileBackend, error) {
+	if len(p) < 1 {
+		return nil, fmt.Errorf("empty passphrase")
+	}
+
+	s, err := b.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(s.Salt) < 1 {
+		s.Salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, s.Salt); err != nil {
+			return nil, err
+		}
+	}
+	b.salt = s.Salt
+
+	b.key, err = scrypt.Key([]byte(p), s.Salt, 32768, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when doing
+// a Store().  For the file backend this will always be false, since encryption is done locally.
+func (b *FileBackend) KmsRequired() bool {
+	return b.kmsRequired
+}
+
+// Store encrypts the value and writes it to the store file using the provided key.  The key name is
+// used as additional authenticated data, so an encrypted value is only valid for the key it was stored as.
+func (b *FileBackend) Store(key string, value interface{}) error {
+	if len(key) < 1 {
+		return fmt.Errorf("empty key")
+	}
+
+	r, err := readBinary(value)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < 1 {
+		return fmt.Errorf("empty value")
+	}
+
+	gcm, err := b.cipher()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, err := b.load()
+	if err != nil {
+		return err
+	}
+
+	if len(s.Salt) < 1 {
+		s.Salt = b.salt
+	}
+	s.Secrets[key] = base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, data, []byte(key)))
+
+	log.Debugf("writing key %s to file %s", key, b.path)
+	return b.save(s)
+}
+
+// Fetch reads the encrypted value of the provided key from the store file, and returns the decrypted value.
+func (b *FileBackend) Fetch(key string) ([]byte, error) {
+	gcm, err := b.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	s, err := b.load()
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := s.Secrets[key]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+
+	data, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted value for %s", key)
+	}
+
+	n := gcm.NonceSize()
+	return gcm.Open(nil, data[:n], data[n:], []byte(key))
+}
+
+// List returns the sorted keys in the store file which start with the provided prefix.
+func (b *FileBackend) List(prefix string) ([]string, error) {
+	b.mu.Lock()
+	s, err := b.load()
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0)
+	for k := range s.Secrets {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete removes the provided key from the store file.
+func (b *FileBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, err := b.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := s.Secrets[key]; !ok {
+		return ErrSecretNotFound
+	}
+	delete(s.Secrets, key)
+
+	log.Debugf("deleting key %s from file %s", key, b.path)
+	return b.save(s)
+}
+
+func (b *FileBackend) cipher() (cipher.AEAD, error) {
+	if len(b.key) < 1 {
+		return nil, fmt.Errorf("encryption key not set for file backend")
+	}
+
+	c, err := aes.NewCipher(b.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(c)
+}
+
+// a store file which does not exist is treated as an empty store
+func (b *FileBackend) load() (*fileStore, error) {
+	s := &fileStore{Secrets: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("error decoding store file %s: %v", b.path, err)
+	}
+
+	if s.Secrets == nil {
+		s.Secrets = make(map[string]string)
+	}
+
+	return s, nil
+}
+
+// write to a temp file and rename, so a failed write never leaves a truncated store file behind
+func (b *FileBackend) save(s *fileStore) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), b.path)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFileBackend(t *testing.T) (*FileBackend, func()) {
+	dir, err := ioutil.TempDir("", "aws-secrets-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewFileBackend().WithFile(filepath.Join(dir, "secrets.json"))
+	b.key = bytes.Repeat([]byte{0x42}, 32)
+
+	return b, func() { os.RemoveAll(dir) }
+}
+
+func TestNewFileBackend(t *testing.T) {
+	b := NewFileBackend()
+	if b == nil {
+		t.Error("received nil FileBackend object")
+		return
+	}
+
+	if b.KmsRequired() {
+		t.Error("KmsRequired() should be false for FileBackend")
+	}
+}
+
+func TestFileBackend_WithKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aws-secrets-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{0x01}, 32)
+
+	t.Run("raw", func(t *testing.T) {
+		f := filepath.Join(dir, "raw")
+		ioutil.WriteFile(f, key, 0600)
+
+		b, err := NewFileBackend().WithKeyFile(f)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !bytes.Equal(b.key, key) {
+			t.Error("key mismatch")
+		}
+	})
+
+	t.Run("base64", func(t *testing.T) {
+		f := filepath.Join(dir, "b64")
+		ioutil.WriteFile(f, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+
+		b, err := NewFileBackend().WithKeyFile(f)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if !bytes.Equal(b.key, key) {
+			t.Error("key mismatch")
+		}
+	})
+
+	t.Run("bad length", func(t *testing.T) {
+		f := filepath.Join(dir, "short")
+		ioutil.WriteFile(f, []byte("too short"), 0600)
+
+		if _, err := NewFileBackend().WithKeyFile(f); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := NewFileBackend().WithKeyFile(filepath.Join(dir, "missing")); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestFileBackend_WithPassphrase(t *testing.T) {
+	b, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	b, err := b.WithPassphrase("my passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the salt is not written until a secret is stored
+	if _, err := os.Stat(b.path); !os.IsNotExist(err) {
+		t.Errorf("store file created before storing a secret: %v", err)
+	}
+
+	if _, err := b.Fetch("key"); err != ErrSecretNotFound {
+		t.Errorf("did not receive expected error, got: %v", err)
+	}
+
+	if err := b.Store("key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("same passphrase", func(t *testing.T) {
+		b2, err := NewFileBackend().WithFile(b.path).WithPassphrase("my passphrase")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		v, err := b2.Fetch("key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("wrong passphrase", func(t *testing.T) {
+		b2, err := NewFileBackend().WithFile(b.path).WithPassphrase("not my passphrase")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := b2.Fetch("key"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("empty passphrase", func(t *testing.T) {
+		if _, err := NewFileBackend().WithFile(b.path).WithPassphrase(""); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestFileBackend_Store(t *testing.T) {
+	b, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	t.Run("good", func(t *testing.T) {
+		if err := b.Store("key", "secret"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		data, _ := ioutil.ReadFile(b.path)
+		if strings.Contains(string(data), "secret\"") {
+			t.Error("found plaintext value in store file")
+		}
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		if err := b.Store("", "value"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("nil value", func(t *testing.T) {
+		if err := b.Store("my key", nil); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("empty string", func(t *testing.T) {
+		if err := b.Store("a key", ""); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bytes value", func(t *testing.T) {
+		if err := b.Store("a key", []byte("abcdefg")); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		if err := NewFileBackend().WithFile(b.path).Store("k", "v"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestFileBackend_Fetch(t *testing.T) {
+	b, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	if err := b.Store("key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		v, err := b.Fetch("key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := b.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+
+	t.Run("swapped value", func(t *testing.T) {
+		s, _ := b.load()
+		s.Secrets["other"] = s.Secrets["key"]
+		b.save(s)
+
+		if _, err := b.Fetch("other"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestFileBackend_ListDelete(t *testing.T) {
+	b, cleanup := newTestFileBackend(t)
+	defer cleanup()
+
+	for _, k := range []string{"/app/b", "/app/a", "/other"} {
+		if err := b.Store(k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := b.List("/app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if strings.Join(keys, ",") != "/app/a,/app/b" {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := b.Delete("/app/a"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := b.Delete("/app/a"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
+func TestFileBackend_Handlers(t *testing.T) {
+	b, cleanup := newTestFileBackend(t)
+	defer cleanup()
+	sb = b
+
+	t.Run("json", func(t *testing.T) {
+		if errs := jsonHandler(`{"/app/a": "value a", "/app/b": {"k": "v"}}`); errs > 0 {
+			t.Error("got an error when storing a known good value")
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		if err := getHandler("/app/b", buf); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if buf.String() != `{"k":"v"}` {
+			t.Errorf("unexpected value: %s", buf.String())
+		}
+	})
+
+	t.Run("one-shot", func(t *testing.T) {
+		if err := oneShotHandler("/app/c", strings.NewReader("value c")); err != nil {
+			t.Error(err)
+			return
+		}
+
+		buf := new(bytes.Buffer)
+		if err := getHandler("/app/c", buf); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if buf.String() != "value c" {
+			t.Errorf("unexpected value: %s", buf.String())
+		}
+	})
+}
@@ -1,13 +1,23 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
 
 type mockBackend struct {
 	kmsRequired bool
+	data        map[string][]byte
+	stores      int
+	err         error
+	mu          sync.Mutex
 }
 
 func newMockBackend() *mockBackend {
-	return &mockBackend{kmsRequired: false}
+	return &mockBackend{kmsRequired: false, data: make(map[string][]byte)}
 }
 
 // KmsRequired will always return false for the mock backend
@@ -15,8 +25,13 @@ func (b *mockBackend) KmsRequired() bool {
 	return b.kmsRequired
 }
 
-// Store will always succeed, unless you pass a zero-length key or nil value (or zero-length string value)
+// Store will always succeed, unless you pass a zero-length key or nil value (or zero-length string value),
+// or the err field is set
 func (b *mockBackend) Store(key string, value interface{}) error {
+	if b.err != nil {
+		return b.err
+	}
+
 	if len(key) < 1 {
 		return fmt.Errorf("invalid key")
 	}
@@ -32,5 +47,59 @@ func (b *mockBackend) Store(key string, value interface{}) error {
 		}
 	}
 
+	r, err := readBinary(value)
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.data[key] = data
+	b.stores++
+	return nil
+}
+
+// Fetch returns the value previously set with Store, or ErrSecretNotFound if the key was never stored
+func (b *mockBackend) Fetch(key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	v, ok := b.data[key]
+	if !ok {
+		return nil, ErrSecretNotFound
+	}
+	return v, nil
+}
+
+// List returns the sorted keys which start with the provided prefix
+func (b *mockBackend) List(prefix string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	keys := make([]string, 0)
+	for k := range b.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Delete removes the key, or returns ErrSecretNotFound if the key was never stored
+func (b *mockBackend) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.data[key]; !ok {
+		return ErrSecretNotFound
+	}
+	delete(b.data, key)
 	return nil
 }
@@ -2,10 +2,15 @@ package main
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
+	"io/ioutil"
+	"net/url"
 	"os"
 )
 
@@ -13,9 +18,13 @@ import (
 type S3Backend struct {
 	kmsRequired  bool
 	c            *s3manager.Uploader
+	s            s3iface.S3API
 	k            *kms.KMS
 	bucket       string
 	storageClass string
+	kmsKey       string
+	kmsKeyMap    map[string]string
+	tags         map[string]string
 }
 
 // NewS3Backend creates a basic S3 SecretsBackender.  Note that the bucket name is not
@@ -26,14 +35,24 @@ func NewS3Backend() *S3Backend {
 		cls = v
 	}
 
+	c := s3.New(ses, endpointConfig(s3.EndpointsID))
 	return &S3Backend{
 		kmsRequired:  true,
-		c:            s3manager.NewUploader(ses),
-		k:            kms.New(ses),
+		c:            s3manager.NewUploaderWithClient(c),
+		s:            c,
+		k:            kms.New(ses, endpointConfig(kms.EndpointsID)),
 		storageClass: cls,
 	}
 }
 
+// WithSession sets the AWS session used to create the S3 and KMS clients, for example to use a different region
+func (b *S3Backend) WithSession(s *session.Session) *S3Backend {
+	b.s = s3.New(s, endpointConfig(s3.EndpointsID))
+	b.c = s3manager.NewUploaderWithClient(b.s)
+	b.k = kms.New(s, endpointConfig(kms.EndpointsID))
+	return b
+}
+
 // WithBucket sets the S3 bucket name to store the encrypted data. No validation is performed
 // to verify the bucket existence in this method
 func (b *S3Backend) WithBucket(bucket string) *S3Backend {
@@ -48,6 +67,22 @@ func (b *S3Backend) WithStorageClass(cls string) *S3Backend {
 	return b
 }
 
+// WithTags sets the tags applied to the objects uploaded by the backend
+func (b *S3Backend) WithTags(t map[string]string) *S3Backend {
+	b.tags = t
+	return b
+}
+
+// setKmsKey sets the ARN of the KMS key used to encrypt values when calling Store()
+func (b *S3Backend) setKmsKey(k string) {
+	b.kmsKey = k
+}
+
+// setKmsKeyMap sets the ARNs of the KMS keys used to encrypt values for each key name prefix when calling Store()
+func (b *S3Backend) setKmsKeyMap(m map[string]string) {
+	b.kmsKeyMap = m
+}
+
 // KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when
 // doing a Store().  For S3 this will always be true since we need to explicitly provide the KMS
 // key information when storing an object in S3.
@@ -59,6 +94,18 @@ func (b *S3Backend) KmsRequired() bool {
 // The size of the secret value to store in S3 is only limited by the S3 object size limit.  This is
 // currently 5TB
 func (b *S3Backend) Store(key string, value interface{}) error {
+	return b.put(&secret{key: key}, value)
+}
+
+// storeRecord writes the secret value as Store() does, using the KMS key and content type from the secret record
+// if they are set, and tagging the uploaded object with the backend tags and the secret tags.  Since each upload
+// creates a new object (or object version), any existing tags are replaced.
+func (b *S3Backend) storeRecord(s *secret) error {
+	warnUnsupported(s3Svc, s, fieldDescription, fieldTier)
+	return b.put(s, s.value)
+}
+
+func (b *S3Backend) put(s *secret, value interface{}) error {
 	var r io.Reader
 	var err error
 
@@ -72,21 +119,93 @@ func (b *S3Backend) Store(key string, value interface{}) error {
 		}
 	}
 
+	log.Debugf("uploading S3 object to %s", s.key)
+	o, err := b.c.Upload(b.uploadInput(s, r))
+	if err != nil {
+		return err
+	}
+	log.Debugf("object uploaded to %s", o.Location)
+
+	return nil
+}
+
+func (b *S3Backend) uploadInput(s *secret, r io.Reader) *s3manager.UploadInput {
 	i := s3manager.UploadInput{
 		Bucket:               aws.String(b.bucket),
-		Key:                  aws.String(key),
+		Key:                  aws.String(s.key),
 		Body:                 r,
 		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAwsKms),
-		SSEKMSKeyId:          aws.String(keyArn.String()),
 		StorageClass:         aws.String(b.storageClass),
 	}
 
-	log.Debugf("uploading S3 object to %s", key)
-	o, err := b.c.Upload(&i)
+	// without a key id, S3 uses the AWS managed key for the bucket
+	if k := s.kmsKey; len(k) > 0 {
+		i.SSEKMSKeyId = aws.String(k)
+	} else if k = kmsKeyFor(s.key, b.kmsKeyMap, b.kmsKey); len(k) > 0 {
+		i.SSEKMSKeyId = aws.String(k)
+	}
+
+	if len(s.contentType) > 0 {
+		i.ContentType = aws.String(s.contentType)
+	}
+
+	if tags := mergeTags(b.tags, s.tags); len(tags) > 0 {
+		// the object tags are sent as a url query string in the x-amz-tagging header
+		q := make(url.Values)
+		for k, v := range tags {
+			q.Set(k, v)
+		}
+		i.Tagging = aws.String(q.Encode())
+	}
+
+	return &i
+}
+
+// Fetch retrieves the object from the bucket using the provided key as the object's key in the bucket.
+// S3 transparently decrypts the object, so the caller only requires kms:Decrypt permission on the key.
+func (b *S3Backend) Fetch(key string) ([]byte, error) {
+	i := s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(key),
+	}
+
+	log.Debugf("downloading S3 object from %s", key)
+	o, err := b.s.GetObject(&i)
 	if err != nil {
-		return err
+		if e, ok := err.(awserr.Error); ok && e.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
 	}
-	log.Debugf("object uploaded to %s", o.Location)
+	defer o.Body.Close()
 
-	return nil
+	return ioutil.ReadAll(o.Body)
+}
+
+// List returns the keys of all objects in the bucket which start with the provided prefix.
+func (b *S3Backend) List(prefix string) ([]string, error) {
+	i := s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	keys := make([]string, 0)
+	err := b.s.ListObjectsV2Pages(&i, func(o *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range o.Contents {
+			keys = append(keys, *obj.Key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete removes the object from the bucket using the provided key as the object's key in the bucket.
+func (b *S3Backend) Delete(key string) error {
+	log.Debugf("deleting S3 object %s", key)
+	_, err := b.s.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(b.bucket), Key: aws.String(key)})
+	return err
 }
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -13,6 +20,35 @@ import (
 // to mock out the s3manager stuff, we won't be able to do any testing on
 // the Store() method
 
+type mockS3Client struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (m *mockS3Client) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	v, ok := m.objects[*input.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "key not found", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(v))}, nil
+}
+
+func (m *mockS3Client) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	o := new(s3.ListObjectsV2Output)
+	for k := range m.objects {
+		if strings.HasPrefix(k, *input.Prefix) {
+			o.Contents = append(o.Contents, &s3.Object{Key: aws.String(k)})
+		}
+	}
+	fn(o, true)
+	return nil
+}
+
+func (m *mockS3Client) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, *input.Key)
+	return new(s3.DeleteObjectOutput), nil
+}
+
 func TestNewS3Backend(t *testing.T) {
 	t.Run("nil session", func(t *testing.T) {
 		d := NewS3Backend()
@@ -72,3 +108,99 @@ func TestS3Backend_WithStorageClass(t *testing.T) {
 		t.Error("storage class mismatch")
 	}
 }
+
+func TestS3Backend_Fetch(t *testing.T) {
+	d := NewS3Backend().WithBucket("my-bucket")
+	d.s = &mockS3Client{objects: map[string][]byte{"my/key": []byte("secret")}}
+
+	t.Run("good", func(t *testing.T) {
+		v, err := d.Fetch("my/key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := d.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
+func TestS3Backend_ListDelete(t *testing.T) {
+	d := NewS3Backend().WithBucket("my-bucket")
+	d.s = &mockS3Client{objects: map[string][]byte{"app/a": []byte("1"), "app/b": []byte("2"), "other": []byte("3")}}
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := d.List("app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(keys) != 2 {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := d.Delete("app/a"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := d.Fetch("app/a"); err != ErrSecretNotFound {
+			t.Errorf("object was not deleted: %v", err)
+		}
+	})
+}
+
+func TestS3Backend_UploadInput(t *testing.T) {
+	b := NewS3Backend().WithBucket("bucket").WithTags(map[string]string{"team": "ops"})
+	b.setKmsKey("backend-key")
+
+	t.Run("backend tags", func(t *testing.T) {
+		i := b.uploadInput(&secret{key: "key"}, strings.NewReader("v"))
+		if i.Tagging == nil || *i.Tagging != "team=ops" || *i.SSEKMSKeyId != "backend-key" || i.ContentType != nil {
+			t.Errorf("unexpected upload input: %v", i)
+		}
+	})
+
+	t.Run("no tags", func(t *testing.T) {
+		b := NewS3Backend().WithBucket("bucket")
+		if i := b.uploadInput(&secret{key: "key"}, strings.NewReader("v")); i.Tagging != nil {
+			t.Errorf("unexpected tagging: %s", *i.Tagging)
+		}
+	})
+
+	t.Run("no kms key", func(t *testing.T) {
+		b := NewS3Backend().WithBucket("bucket")
+		b.setKmsKeyMap(map[string]string{"/team-a/": "team-a-key"})
+
+		if i := b.uploadInput(&secret{key: "/team-b/key"}, strings.NewReader("v")); i.SSEKMSKeyId != nil {
+			t.Errorf("unexpected kms key: %s", *i.SSEKMSKeyId)
+		}
+
+		if i := b.uploadInput(&secret{key: "/team-a/key"}, strings.NewReader("v")); *i.SSEKMSKeyId != "team-a-key" {
+			t.Errorf("unexpected kms key: %s", *i.SSEKMSKeyId)
+		}
+	})
+
+	t.Run("record", func(t *testing.T) {
+		s := &secret{key: "key", tags: map[string]string{"app name": "a&b"}, kmsKey: "record-key", contentType: "application/json"}
+
+		i := b.uploadInput(s, strings.NewReader("v"))
+		if i.Tagging == nil || *i.Tagging != "app+name=a%26b&team=ops" {
+			t.Errorf("unexpected tagging: %v", i.Tagging)
+		}
+
+		if *i.SSEKMSKeyId != "record-key" || *i.ContentType != "application/json" {
+			t.Errorf("unexpected upload input: %v", i)
+		}
+	})
+}
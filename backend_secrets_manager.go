@@ -2,14 +2,22 @@ package main
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 	"io/ioutil"
+	"strings"
 )
 
 // SecretsManagerBackend is the type for storing a KMS encrypted item attribute in AWS Secrets Manager
 type SecretsManagerBackend struct {
 	kmsRequired bool
+	create      bool
+	description string
+	tags        map[string]string
+	kmsKey      string
+	kmsKeyMap   map[string]string
 	c           secretsmanageriface.SecretsManagerAPI
 }
 
@@ -17,10 +25,46 @@ type SecretsManagerBackend struct {
 func NewSecretsManagerBackend() *SecretsManagerBackend {
 	return &SecretsManagerBackend{
 		kmsRequired: false,
-		c:           secretsmanager.New(ses),
+		c:           secretsmanager.New(ses, endpointConfig(secretsmanager.EndpointsID)),
 	}
 }
 
+// WithSession sets the AWS session used to create the Secrets Manager client, for example to use a different region
+func (b *SecretsManagerBackend) WithSession(s *session.Session) *SecretsManagerBackend {
+	b.c = secretsmanager.New(s, endpointConfig(secretsmanager.EndpointsID))
+	return b
+}
+
+// WithCreate instructs the backend to create the Secret resource if it does not exist when calling Store().
+// The secret is created using the KMS key provided to the program, or the service default key if no KMS
+// key was provided.
+func (b *SecretsManagerBackend) WithCreate(c bool) *SecretsManagerBackend {
+	b.create = c
+	return b
+}
+
+// WithDescription sets the description used for Secret resources created by the backend
+func (b *SecretsManagerBackend) WithDescription(d string) *SecretsManagerBackend {
+	b.description = d
+	return b
+}
+
+// WithTags sets the tags applied to the Secret resources written by the backend
+func (b *SecretsManagerBackend) WithTags(t map[string]string) *SecretsManagerBackend {
+	b.tags = t
+	return b
+}
+
+// setKmsKey sets the ARN of the KMS key used to encrypt values when calling Store()
+func (b *SecretsManagerBackend) setKmsKey(k string) {
+	b.kmsKey = k
+}
+
+// setKmsKeyMap sets the ARNs of the KMS keys used to encrypt values for each key name prefix when calling Store()
+func (b *SecretsManagerBackend) setKmsKeyMap(m map[string]string) {
+	b.kmsKeyMap = m
+}
+
 // KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when doing
 // a Store().  For Secrets Manager this will always be false since the key is defined on the Secret
 // definition, and not required when storing values using this backend.
@@ -31,33 +75,190 @@ func (b *SecretsManagerBackend) KmsRequired() bool {
 // Store writes the value to Secrets Manager using the name defined by the key parameter. String
 // values will be stored as SecretString types, any other data type will be stored as a SecretBinary
 // type.  AWS enforces a maximum size of 7168 bytes for the value, so attempting to store values larger
-// than that is likely to result in an error.
+// than that is likely to result in an error.  If the backend was configured using WithCreate(true), a
+// Secret which does not exist will be created with the value.
 func (b *SecretsManagerBackend) Store(key string, value interface{}) error {
-	i := secretsmanager.PutSecretValueInput{SecretId: aws.String(key)}
+	return b.put(&secret{key: key}, value)
+}
+
+// storeRecord writes the secret value as Store() does.  If the secret record sets a description or KMS key, the
+// value is written using UpdateSecret, which also updates the description and KMS key of an existing Secret.
+// The backend tags and the secret tags are then added to the Secret using TagResource, which replaces the values
+// of existing tags with the same key.  Secrets created by the backend are tagged as part of the CreateSecret call.
+func (b *SecretsManagerBackend) storeRecord(s *secret) error {
+	warnUnsupported(secretsSvc, s, fieldTier, fieldContentType)
+	return b.put(s, s.value)
+}
+
+func (b *SecretsManagerBackend) put(s *secret, value interface{}) error {
+	var str *string
+	var bin []byte
 
 	switch t := value.(type) {
 	case string:
-		i.SecretString = aws.String(t)
+		str = aws.String(t)
 	default:
 		r, err := readBinary(value)
 		if err != nil {
 			return err
 		}
 
-		data, err := ioutil.ReadAll(r)
+		bin, err = ioutil.ReadAll(r)
 		if err != nil {
 			return err
 		}
+	}
+
+	tags := mergeTags(b.tags, s.tags)
+
+	var err error
+	if len(s.description) > 0 || len(s.kmsKey) > 0 {
+		err = b.update(s, str, bin)
+	} else {
+		i := secretsmanager.PutSecretValueInput{SecretId: aws.String(s.key), SecretString: str, SecretBinary: bin}
 
-		i.SecretBinary = data
+		log.Debugf("setting secret name %s", s.key)
+		var o *secretsmanager.PutSecretValueOutput
+		if o, err = b.c.PutSecretValue(&i); err == nil {
+			log.Debugf("set secret %s, version %s", *o.Name, *o.VersionId)
+		}
+	}
+
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == secretsmanager.ErrCodeResourceNotFoundException && b.create {
+			return b.createSecret(s, str, bin, tags)
+		}
+		return err
+	}
+
+	if len(tags) > 0 {
+		return b.tag(s.key, tags)
+	}
+
+	return nil
+}
+
+func (b *SecretsManagerBackend) update(s *secret, str *string, bin []byte) error {
+	i := secretsmanager.UpdateSecretInput{SecretId: aws.String(s.key), SecretString: str, SecretBinary: bin}
+
+	if len(s.description) > 0 {
+		i.Description = aws.String(s.description)
 	}
 
-	log.Debugf("setting secret name %s", key)
-	o, err := b.c.PutSecretValue(&i)
+	if len(s.kmsKey) > 0 {
+		i.KmsKeyId = aws.String(s.kmsKey)
+	}
+
+	log.Debugf("updating secret name %s", s.key)
+	o, err := b.c.UpdateSecret(&i)
 	if err != nil {
 		return err
 	}
-	log.Debugf("set secret %s, version %s", *o.Name, *o.VersionId)
+	log.Debugf("updated secret %s, version %s", *o.Name, aws.StringValue(o.VersionId))
 
 	return nil
 }
+
+func (b *SecretsManagerBackend) tag(key string, tags map[string]string) error {
+	i := secretsmanager.TagResourceInput{SecretId: aws.String(key)}
+
+	for k, v := range tags {
+		i.Tags = append(i.Tags, &secretsmanager.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	log.Debugf("tagging secret name %s", key)
+	_, err := b.c.TagResource(&i)
+	return err
+}
+
+// the description and KMS key from the secret record take precedence over the backend settings, and the
+// KMS key map takes precedence over the backend KMS key
+func (b *SecretsManagerBackend) createSecret(s *secret, str *string, bin []byte, tags map[string]string) error {
+	i := secretsmanager.CreateSecretInput{
+		Name:         aws.String(s.key),
+		SecretString: str,
+		SecretBinary: bin,
+	}
+
+	if len(s.kmsKey) > 0 {
+		i.KmsKeyId = aws.String(s.kmsKey)
+	} else if k := kmsKeyFor(s.key, b.kmsKeyMap, b.kmsKey); len(k) > 0 {
+		i.KmsKeyId = aws.String(k)
+	}
+
+	if len(s.description) > 0 {
+		i.Description = aws.String(s.description)
+	} else if len(b.description) > 0 {
+		i.Description = aws.String(b.description)
+	}
+
+	for k, v := range tags {
+		i.Tags = append(i.Tags, &secretsmanager.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	log.Debugf("creating secret name %s", *i.Name)
+	o, err := b.c.CreateSecret(&i)
+	if err != nil {
+		return err
+	}
+	log.Debugf("created secret %s, version %s", *o.Name, *o.VersionId)
+
+	return nil
+}
+
+// Fetch retrieves the current value of the secret using the name defined by the key parameter.
+// SecretString values are returned as their string bytes, SecretBinary values are returned as-is.
+func (b *SecretsManagerBackend) Fetch(key string) ([]byte, error) {
+	i := secretsmanager.GetSecretValueInput{SecretId: aws.String(key)}
+
+	log.Debugf("reading secret name %s", key)
+	o, err := b.c.GetSecretValue(&i)
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+	log.Debugf("read secret %s, version %s", *o.Name, *o.VersionId)
+
+	if o.SecretString != nil {
+		return []byte(*o.SecretString), nil
+	}
+	return o.SecretBinary, nil
+}
+
+// List returns the names of all secrets whose name starts with the provided prefix.
+func (b *SecretsManagerBackend) List(prefix string) ([]string, error) {
+	i := secretsmanager.ListSecretsInput{
+		Filters: []*secretsmanager.Filter{
+			{
+				Key:    aws.String(secretsmanager.FilterNameStringTypeName),
+				Values: aws.StringSlice([]string{prefix}),
+			},
+		},
+	}
+
+	keys := make([]string, 0)
+	err := b.c.ListSecretsPages(&i, func(o *secretsmanager.ListSecretsOutput, last bool) bool {
+		for _, s := range o.SecretList {
+			// the name filter is not case-sensitive, so double check the results
+			if strings.HasPrefix(*s.Name, prefix) {
+				keys = append(keys, *s.Name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete schedules the secret using the name defined by the key parameter for deletion.  The secret
+// is deleted using the default recovery window, so it can be restored if deleted by mistake.
+func (b *SecretsManagerBackend) Delete(key string) error {
+	log.Debugf("deleting secret name %s", key)
+	_, err := b.c.DeleteSecret(&secretsmanager.DeleteSecretInput{SecretId: aws.String(key)})
+	return err
+}
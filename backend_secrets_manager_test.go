@@ -3,14 +3,21 @@ package main
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"strings"
 	"testing"
 )
 
 type mockSecretsManagerClient struct {
 	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]*secretsmanager.GetSecretValueOutput
+	created []*secretsmanager.CreateSecretInput
+	tagged  []*secretsmanager.TagResourceInput
+	updated []*secretsmanager.UpdateSecretInput
+	strict  bool // fail PutSecretValue calls for secrets which do not exist, like the real service
 }
 
 func (m *mockSecretsManagerClient) PutSecretValue(input *secretsmanager.PutSecretValueInput) (*secretsmanager.PutSecretValueOutput, error) {
@@ -23,9 +30,95 @@ func (m *mockSecretsManagerClient) PutSecretValue(input *secretsmanager.PutSecre
 		return nil, fmt.Errorf("secret value too short")
 	}
 
+	if m.strict {
+		if _, ok := m.secrets[*input.SecretId]; !ok {
+			return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+		}
+	}
+
+	if m.secrets == nil {
+		m.secrets = make(map[string]*secretsmanager.GetSecretValueOutput)
+	}
+	m.secrets[*input.SecretId] = &secretsmanager.GetSecretValueOutput{
+		Name:         input.SecretId,
+		VersionId:    aws.String("VersionX"),
+		SecretString: input.SecretString,
+		SecretBinary: input.SecretBinary,
+	}
+
 	return &secretsmanager.PutSecretValueOutput{Name: input.SecretId, VersionId: aws.String("VersionX")}, nil
 }
 
+func (m *mockSecretsManagerClient) CreateSecret(input *secretsmanager.CreateSecretInput) (*secretsmanager.CreateSecretOutput, error) {
+	if _, ok := m.secrets[*input.Name]; ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceExistsException, "secret exists", nil)
+	}
+
+	if m.secrets == nil {
+		m.secrets = make(map[string]*secretsmanager.GetSecretValueOutput)
+	}
+	m.secrets[*input.Name] = &secretsmanager.GetSecretValueOutput{
+		Name:         input.Name,
+		VersionId:    aws.String("VersionX"),
+		SecretString: input.SecretString,
+		SecretBinary: input.SecretBinary,
+	}
+	m.created = append(m.created, input)
+
+	return &secretsmanager.CreateSecretOutput{Name: input.Name, VersionId: aws.String("VersionX")}, nil
+}
+
+func (m *mockSecretsManagerClient) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	o, ok := m.secrets[*input.SecretId]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	return o, nil
+}
+
+func (m *mockSecretsManagerClient) ListSecretsPages(input *secretsmanager.ListSecretsInput, fn func(*secretsmanager.ListSecretsOutput, bool) bool) error {
+	o := new(secretsmanager.ListSecretsOutput)
+	for k := range m.secrets {
+		// mimic the case-insensitive name filter
+		if strings.HasPrefix(strings.ToLower(k), strings.ToLower(*input.Filters[0].Values[0])) {
+			o.SecretList = append(o.SecretList, &secretsmanager.SecretListEntry{Name: aws.String(k)})
+		}
+	}
+	fn(o, true)
+	return nil
+}
+
+func (m *mockSecretsManagerClient) UpdateSecret(input *secretsmanager.UpdateSecretInput) (*secretsmanager.UpdateSecretOutput, error) {
+	o, ok := m.secrets[*input.SecretId]
+	if !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+
+	if input.SecretString != nil || input.SecretBinary != nil {
+		o.SecretString = input.SecretString
+		o.SecretBinary = input.SecretBinary
+	}
+	m.updated = append(m.updated, input)
+
+	return &secretsmanager.UpdateSecretOutput{Name: input.SecretId, VersionId: aws.String("VersionX")}, nil
+}
+
+func (m *mockSecretsManagerClient) TagResource(input *secretsmanager.TagResourceInput) (*secretsmanager.TagResourceOutput, error) {
+	if _, ok := m.secrets[*input.SecretId]; !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	m.tagged = append(m.tagged, input)
+	return new(secretsmanager.TagResourceOutput), nil
+}
+
+func (m *mockSecretsManagerClient) DeleteSecret(input *secretsmanager.DeleteSecretInput) (*secretsmanager.DeleteSecretOutput, error) {
+	if _, ok := m.secrets[*input.SecretId]; !ok {
+		return nil, awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "secret not found", nil)
+	}
+	delete(m.secrets, *input.SecretId)
+	return &secretsmanager.DeleteSecretOutput{Name: input.SecretId}, nil
+}
+
 func TestNewSecretsManagerBackend(t *testing.T) {
 	t.Run("nil session", func(t *testing.T) {
 		b := NewSecretsManagerBackend()
@@ -105,3 +198,197 @@ func TestSecretsManagerBackend_Store(t *testing.T) {
 		}
 	})
 }
+
+func TestSecretsManagerBackend_Fetch(t *testing.T) {
+	b := NewSecretsManagerBackend()
+	b.c = new(mockSecretsManagerClient)
+
+	if err := b.Store("string", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Store("binary", []byte("abcdefg")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("string", func(t *testing.T) {
+		v, err := b.Fetch("string")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		v, err := b.Fetch("binary")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "abcdefg" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := b.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
+func TestSecretsManagerBackend_List(t *testing.T) {
+	b := NewSecretsManagerBackend()
+	b.c = new(mockSecretsManagerClient)
+
+	for _, k := range []string{"app/a", "app/b", "App/c", "other"} {
+		if err := b.Store(k, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := b.List("app/")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(keys) != 2 {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+func TestSecretsManagerBackend_Delete(t *testing.T) {
+	b := NewSecretsManagerBackend()
+	b.c = new(mockSecretsManagerClient)
+
+	if err := b.Store("app/a", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Delete("app/a"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err := b.Fetch("app/a"); err != ErrSecretNotFound {
+		t.Errorf("secret was not deleted: %v", err)
+	}
+}
+
+func TestSecretsManagerBackend_StoreCreate(t *testing.T) {
+	m := &mockSecretsManagerClient{strict: true}
+
+	t.Run("not found", func(t *testing.T) {
+		b := NewSecretsManagerBackend()
+		b.c = m
+
+		if err := b.Store("new", "secret"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		b := NewSecretsManagerBackend().WithCreate(true).WithDescription("my secret").WithTags(map[string]string{"team": "me"})
+		b.c = m
+
+		if err := b.Store("new", "secret"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.created) != 1 {
+			t.Errorf("unexpected number of created secrets: %d", len(m.created))
+			return
+		}
+
+		c := m.created[0]
+		if *c.Description != "my secret" || len(c.Tags) != 1 || c.KmsKeyId != nil {
+			t.Errorf("unexpected create input: %v", c)
+		}
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		b := NewSecretsManagerBackend().WithCreate(true)
+		b.c = m
+
+		if err := b.Store("new", "updated"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.created) != 1 {
+			t.Errorf("unexpected number of created secrets: %d", len(m.created))
+		}
+	})
+
+	t.Run("with key", func(t *testing.T) {
+		b := NewSecretsManagerBackend().WithCreate(true)
+		b.setKmsKey("arn:aws:kms:us-east-1:01234567891:key/4d4f2a2c-6bc6-4d9b-a50b-7d6f60c761c4")
+		b.c = m
+
+		if err := b.Store("keyed", "secret"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if c := m.created[len(m.created)-1]; c.KmsKeyId == nil || *c.KmsKeyId != b.kmsKey {
+			t.Errorf("unexpected KMS key: %v", c.KmsKeyId)
+		}
+	})
+}
+
+func TestSecretsManagerBackend_StoreRecord(t *testing.T) {
+	m := &mockSecretsManagerClient{strict: true}
+	b := NewSecretsManagerBackend().WithCreate(true).WithDescription("default").WithTags(map[string]string{"team": "ops"})
+	b.c = m
+
+	t.Run("create", func(t *testing.T) {
+		s := &secret{key: "new", value: "secret", description: "my secret", tags: map[string]string{"env": "dev"}}
+		if err := b.storeRecord(s); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.created) != 1 || len(m.created[0].Tags) != 2 || len(m.tagged) > 0 {
+			t.Errorf("unexpected create or tag calls: %v %v", m.created, m.tagged)
+			return
+		}
+
+		if *m.created[0].Description != "my secret" {
+			t.Errorf("unexpected description: %s", *m.created[0].Description)
+		}
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		if err := b.storeRecord(&secret{key: "new", value: "updated", tags: map[string]string{"env": "prod"}}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.tagged) != 1 || len(m.tagged[0].Tags) != 2 || len(m.updated) > 0 {
+			t.Errorf("unexpected tag or update calls: %v %v", m.tagged, m.updated)
+		}
+	})
+
+	t.Run("update metadata", func(t *testing.T) {
+		if err := b.storeRecord(&secret{key: "new", value: "again", description: "changed", kmsKey: "alias/other"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.updated) != 1 || *m.updated[0].Description != "changed" || *m.updated[0].KmsKeyId != "alias/other" {
+			t.Errorf("unexpected update calls: %v", m.updated)
+			return
+		}
+
+		if v, _ := b.Fetch("new"); string(v) != "again" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+}
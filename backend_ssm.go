@@ -3,15 +3,21 @@ package main
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 	"reflect"
+	"strings"
 )
 
 // ParameterStoreBackend is the type for storing a KMS encrypted item attribute in SSM Parameter Store
 type ParameterStoreBackend struct {
 	kmsRequired bool
 	tier        string
+	kmsKey      string
+	kmsKeyMap   map[string]string
+	tags        map[string]string
 	c           ssmiface.SSMAPI
 }
 
@@ -20,10 +26,16 @@ func NewParameterStoreBackend() *ParameterStoreBackend {
 	return &ParameterStoreBackend{
 		kmsRequired: false,
 		tier:        ssm.ParameterTierStandard,
-		c:           ssm.New(ses),
+		c:           ssm.New(ses, endpointConfig(ssm.EndpointsID)),
 	}
 }
 
+// WithSession sets the AWS session used to create the SSM client, for example to use a different region
+func (b *ParameterStoreBackend) WithSession(s *session.Session) *ParameterStoreBackend {
+	b.c = ssm.New(s, endpointConfig(ssm.EndpointsID))
+	return b
+}
+
 // WithAdvanced instructs the backend to store the parameters as Advanced Parameters, which allow
 // more parameters in a region, and larger values.
 func (b *ParameterStoreBackend) WithAdvanced(a bool) *ParameterStoreBackend {
@@ -34,6 +46,22 @@ func (b *ParameterStoreBackend) WithAdvanced(a bool) *ParameterStoreBackend {
 	return b
 }
 
+// WithTags sets the tags applied to the parameters written by the backend
+func (b *ParameterStoreBackend) WithTags(t map[string]string) *ParameterStoreBackend {
+	b.tags = t
+	return b
+}
+
+// setKmsKey sets the ARN of the KMS key used to encrypt values when calling Store()
+func (b *ParameterStoreBackend) setKmsKey(k string) {
+	b.kmsKey = k
+}
+
+// setKmsKeyMap sets the ARNs of the KMS keys used to encrypt values for each key name prefix when calling Store()
+func (b *ParameterStoreBackend) setKmsKeyMap(m map[string]string) {
+	b.kmsKeyMap = m
+}
+
 // KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when doing
 // a Store().  For Parameter Store this will always be false since the service will use the service
 // default KMS key if one is not explicitly supplied as part of the command.
@@ -45,26 +73,53 @@ func (b *ParameterStoreBackend) KmsRequired() bool {
 // values will be stored as SecureString types.  AWS enforces a maximum size of 4096 bytes for
 // the value, so attempting to store values larger than that is likely to result in an error.
 func (b *ParameterStoreBackend) Store(key string, value interface{}) error {
+	return b.put(&secret{key: key}, value)
+}
+
+// storeRecord writes the secret value as Store() does, using the description, KMS key, and tier from the
+// secret record if they are set.  The backend tags and the secret tags are added to the parameter after it
+// is written, since tags can not be set by PutParameter when overwriting an existing parameter.  This is
+// done using AddTagsToResource, which replaces the values of existing tags with the same key.
+func (b *ParameterStoreBackend) storeRecord(s *secret) error {
+	warnUnsupported(ssmSvc, s, fieldContentType)
+	return b.put(s, s.value)
+}
+
+func (b *ParameterStoreBackend) put(s *secret, value interface{}) error {
 	switch t := value.(type) {
 	case string:
 		i := ssm.PutParameterInput{
-			Name:      aws.String(key),
+			Name:      aws.String(s.key),
 			Value:     aws.String(t),
 			Type:      aws.String(ssm.ParameterTypeSecureString),
 			Tier:      aws.String(b.tier),
 			Overwrite: aws.Bool(true),
 		}
 
-		if len(kmsKeyArg) > 0 {
-			i.KeyId = aws.String(keyArn.String())
+		if len(s.tier) > 0 {
+			i.Tier = aws.String(s.tier)
+		}
+
+		if len(s.description) > 0 {
+			i.Description = aws.String(s.description)
+		}
+
+		if len(s.kmsKey) > 0 {
+			i.KeyId = aws.String(s.kmsKey)
+		} else if k := kmsKeyFor(s.key, b.kmsKeyMap, b.kmsKey); len(k) > 0 {
+			i.KeyId = aws.String(k)
 		}
 
-		log.Debugf("writing parameter name %s", key)
+		log.Debugf("writing parameter name %s", s.key)
 		o, err := b.c.PutParameter(&i)
 		if err != nil {
 			return err
 		}
-		log.Debugf("set parameter %s, version %d", key, *o.Version)
+		log.Debugf("set parameter %s, version %d", s.key, *o.Version)
+
+		if tags := mergeTags(b.tags, s.tags); len(tags) > 0 {
+			return b.tag(s.key, tags)
+		}
 	case nil:
 		return fmt.Errorf("nil value detected")
 	default:
@@ -72,3 +127,78 @@ func (b *ParameterStoreBackend) Store(key string, value interface{}) error {
 	}
 	return nil
 }
+
+func (b *ParameterStoreBackend) tag(key string, tags map[string]string) error {
+	i := ssm.AddTagsToResourceInput{
+		ResourceId:   aws.String(key),
+		ResourceType: aws.String(ssm.ResourceTypeForTaggingParameter),
+	}
+
+	for k, v := range tags {
+		i.Tags = append(i.Tags, &ssm.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	log.Debugf("tagging parameter name %s", key)
+	_, err := b.c.AddTagsToResource(&i)
+	return err
+}
+
+// Fetch retrieves the decrypted value of the parameter using the name defined by the key parameter.
+func (b *ParameterStoreBackend) Fetch(key string) ([]byte, error) {
+	i := ssm.GetParameterInput{
+		Name:           aws.String(key),
+		WithDecryption: aws.Bool(true),
+	}
+
+	log.Debugf("reading parameter name %s", key)
+	o, err := b.c.GetParameter(&i)
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == ssm.ErrCodeParameterNotFound {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+	log.Debugf("read parameter %s, version %d", key, *o.Parameter.Version)
+
+	return []byte(*o.Parameter.Value), nil
+}
+
+// List returns the names of all parameters in the path hierarchy under the provided prefix.  The
+// prefix must be a parameter path, starting with a '/'.
+func (b *ParameterStoreBackend) List(prefix string) ([]string, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		return nil, fmt.Errorf("parameter path %s must start with /", prefix)
+	}
+
+	path := strings.TrimSuffix(prefix, "/")
+	if len(path) < 1 {
+		path = "/"
+	}
+
+	i := ssm.GetParametersByPathInput{
+		Path:      aws.String(path),
+		Recursive: aws.Bool(true),
+	}
+
+	keys := make([]string, 0)
+	err := b.c.GetParametersByPathPages(&i, func(o *ssm.GetParametersByPathOutput, last bool) bool {
+		for _, p := range o.Parameters {
+			if strings.HasPrefix(*p.Name, prefix) {
+				keys = append(keys, *p.Name)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete removes the parameter using the name defined by the key parameter.
+func (b *ParameterStoreBackend) Delete(key string) error {
+	log.Debugf("deleting parameter name %s", key)
+	_, err := b.c.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(key)})
+	return err
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"strings"
 	"testing"
 )
 
 type mockSsmClient struct {
 	ssmiface.SSMAPI
+	params map[string]string
+	tags   map[string]map[string]string
+	last   *ssm.PutParameterInput
 }
 
 func (m *mockSsmClient) PutParameter(input *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
@@ -23,9 +27,61 @@ func (m *mockSsmClient) PutParameter(input *ssm.PutParameterInput) (*ssm.PutPara
 		return nil, fmt.Errorf("parameter value too short")
 	}
 
+	if m.params == nil {
+		m.params = make(map[string]string)
+	}
+	m.params[*input.Name] = *input.Value
+	m.last = input
+
 	return &ssm.PutParameterOutput{Version: aws.Int64(1)}, nil
 }
 
+func (m *mockSsmClient) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	v, ok := m.params[*input.Name]
+	if !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(v), Version: aws.Int64(1)}}, nil
+}
+
+func (m *mockSsmClient) GetParametersByPathPages(input *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	o := new(ssm.GetParametersByPathOutput)
+	for k, v := range m.params {
+		if strings.HasPrefix(k, *input.Path) {
+			o.Parameters = append(o.Parameters, &ssm.Parameter{Name: aws.String(k), Value: aws.String(v)})
+		}
+	}
+	fn(o, true)
+	return nil
+}
+
+func (m *mockSsmClient) DeleteParameter(input *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	if _, ok := m.params[*input.Name]; !ok {
+		return nil, awserr.New(ssm.ErrCodeParameterNotFound, "parameter not found", nil)
+	}
+	delete(m.params, *input.Name)
+	return new(ssm.DeleteParameterOutput), nil
+}
+
+func (m *mockSsmClient) AddTagsToResource(input *ssm.AddTagsToResourceInput) (*ssm.AddTagsToResourceOutput, error) {
+	if _, ok := m.params[*input.ResourceId]; !ok {
+		return nil, awserr.New(ssm.ErrCodeInvalidResourceId, "parameter not found", nil)
+	}
+
+	if m.tags == nil {
+		m.tags = make(map[string]map[string]string)
+	}
+	if m.tags[*input.ResourceId] == nil {
+		m.tags[*input.ResourceId] = make(map[string]string)
+	}
+
+	for _, t := range input.Tags {
+		m.tags[*input.ResourceId][*t.Key] = *t.Value
+	}
+	return new(ssm.AddTagsToResourceOutput), nil
+}
+
 func TestNewParameterStoreBackend(t *testing.T) {
 	t.Run("nil session", func(t *testing.T) {
 		b := NewParameterStoreBackend()
@@ -106,11 +162,37 @@ func TestParameterStoreBackend_Store(t *testing.T) {
 	})
 }
 
+func TestParameterStoreBackend_Fetch(t *testing.T) {
+	b := NewParameterStoreBackend()
+	b.c = new(mockSsmClient)
+
+	if err := b.Store("/my/key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("good", func(t *testing.T) {
+		v, err := b.Fetch("/my/key")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := b.Fetch("/not/there"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
 func TestParameterStoreBackend_StoreWithKey(t *testing.T) {
 	b := NewParameterStoreBackend()
 	b.c = new(mockSsmClient)
-	kmsKeyArg = "key"
-	keyArn, _ = arn.Parse("arn:aws:kms:us-east-1:01234567891:key/4d4f2a2c-6bc6-4d9b-a50b-7d6f60c761c4")
+	b.setKmsKey("arn:aws:kms:us-east-1:01234567891:key/4d4f2a2c-6bc6-4d9b-a50b-7d6f60c761c4")
 
 	t.Run("string value", func(t *testing.T) {
 		if err := b.Store("k", "secret value"); err != nil {
@@ -137,3 +219,89 @@ func TestParameterStoreBackend_WithAdvanced(t *testing.T) {
 		}
 	})
 }
+
+func TestParameterStoreBackend_List(t *testing.T) {
+	b := NewParameterStoreBackend()
+	b.c = &mockSsmClient{params: map[string]string{"/app/a": "1", "/app/b/c": "2", "/application": "3", "/other": "4"}}
+
+	t.Run("good", func(t *testing.T) {
+		keys, err := b.List("/app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(keys) != 2 {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("not a path", func(t *testing.T) {
+		if _, err := b.List("app"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestParameterStoreBackend_Delete(t *testing.T) {
+	b := NewParameterStoreBackend()
+	b.c = &mockSsmClient{params: map[string]string{"/app/a": "1"}}
+
+	if err := b.Delete("/app/a"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := b.Delete("/app/a"); err == nil {
+		t.Error("did not receive expected error")
+	}
+}
+
+func TestParameterStoreBackend_StoreRecord(t *testing.T) {
+	t.Run("no tags", func(t *testing.T) {
+		m := new(mockSsmClient)
+		b := NewParameterStoreBackend()
+		b.c = m
+
+		if err := b.Store("/app/a", "secret"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m.tags) > 0 {
+			t.Errorf("unexpected tags: %v", m.tags)
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		m := new(mockSsmClient)
+		b := NewParameterStoreBackend().WithTags(map[string]string{"team": "ops", "env": "dev"})
+		b.c = m
+
+		if err := b.storeRecord(&secret{key: "/app/a", value: "secret", tags: map[string]string{"env": "prod"}}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if tags := m.tags["/app/a"]; len(tags) != 2 || tags["team"] != "ops" || tags["env"] != "prod" {
+			t.Errorf("unexpected tags: %v", tags)
+		}
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		m := new(mockSsmClient)
+		b := NewParameterStoreBackend()
+		b.setKmsKey("backend-key")
+		b.c = m
+
+		s := &secret{key: "/app/a", value: "secret", description: "my secret", kmsKey: "alias/record", tier: ssm.ParameterTierAdvanced}
+		if err := b.storeRecord(s); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if *m.last.Description != "my secret" || *m.last.KeyId != "alias/record" || *m.last.Tier != ssm.ParameterTierAdvanced {
+			t.Errorf("unexpected put parameter input: %v", m.last)
+		}
+	})
+}
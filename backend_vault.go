@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the field name used to store plain string values in the Vault secret data
+const vaultValueField = "value"
+
+// VaultBackend is the type for storing values in a HashiCorp Vault KV version 2 secrets engine
+type VaultBackend struct {
+	kmsRequired bool
+	c           *http.Client
+	addr        string
+	token       string
+	mount       string
+}
+
+// NewVaultBackend creates a Vault KV v2 SecretsBackender.  The Vault server address and token are
+// taken from the VAULT_ADDR and VAULT_TOKEN environment variables, and the secrets engine mount
+// defaults to "secret".  See WithAppRole() for authenticating without a token.
+func NewVaultBackend() *VaultBackend {
+	addr := "http://127.0.0.1:8200"
+	if v, ok := os.LookupEnv("VAULT_ADDR"); ok {
+		addr = v
+	}
+
+	return &VaultBackend{
+		kmsRequired: false,
+		c:           &http.Client{Timeout: 30 * time.Second},
+		addr:        strings.TrimSuffix(addr, "/"),
+		token:       os.Getenv("VAULT_TOKEN"),
+		mount:       "secret",
+	}
+}
+
+// WithMount sets the path of the KV v2 secrets engine mount to store the values in.  No validation is
+// performed to verify the mount exists in this method
+func (b *VaultBackend) WithMount(m string) *VaultBackend {
+	b.mount = strings.Trim(m, "/")
+	return b
+}
+
+// WithAppRole logs in to Vault using the AppRole auth method mounted at the default "approle" path,
+// and uses the returned client token for all further requests.
+func (b *VaultBackend) WithAppRole(roleID, secretID string) (*VaultBackend, error) {
+	body := map[string]string{"role_id": roleID, "secret_id": secretID}
+
+	o := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+
+	if err := b.request(http.MethodPost, "auth/approle/login", body, &o); err != nil {
+		return nil, fmt.Errorf("error logging in to vault: %v", err)
+	}
+
+	b.token = o.Auth.ClientToken
+	return b, nil
+}
+
+// KmsRequired returns whether or not this backend requires a KMS key to encrypt the value when doing
+// a Store().  For Vault this will always be false since Vault encrypts the data itself.
+func (b *VaultBackend) KmsRequired() bool {
+	return b.kmsRequired
+}
+
+// Store writes the value to Vault as a new version of the secret at the path defined by the key
+// parameter.  Values which are a json object are written as the secret's data, any other value is
+// written to the "value" field of the secret data.  Since Vault paths are relative to the mount, any
+// leading '/' on the key is ignored.
+func (b *VaultBackend) Store(key string, value interface{}) error {
+	if len(b.path(key)) < 1 {
+		return fmt.Errorf("empty key")
+	}
+
+	var data map[string]interface{}
+
+	switch t := value.(type) {
+	case map[string]interface{}:
+		data = t
+	default:
+		r, err := readBinary(value)
+		if err != nil {
+			return err
+		}
+
+		v, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if len(v) < 1 {
+			return fmt.Errorf("empty value")
+		}
+		data = vaultData(v)
+	}
+
+	o := struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}{}
+
+	log.Debugf("writing vault secret %s", key)
+	if err := b.request(http.MethodPost, b.mount+"/data/"+b.path(key), map[string]interface{}{"data": data}, &o); err != nil {
+		return err
+	}
+	log.Debugf("set vault secret %s, version %d", key, o.Data.Version)
+
+	return nil
+}
+
+// nested json is re-encoded as a string by the json handler, so see if we can turn it back into a map
+func vaultData(v []byte) map[string]interface{} {
+	var data map[string]interface{}
+	if err := json.Unmarshal(v, &data); err != nil || data == nil {
+		data = map[string]interface{}{vaultValueField: string(v)}
+	}
+	return data
+}
+
+// fetchValue returns the value which Fetch() returns after storing the value, unwrapping the "value" field, or
+// re-encoding the secret data as a json object, so that it can be compared with the stored value
+func (b *VaultBackend) fetchValue(value string) []byte {
+	data := vaultData([]byte(value))
+	if v, ok := data[vaultValueField].(string); ok && len(data) == 1 {
+		return []byte(v)
+	}
+
+	j, err := json.Marshal(data)
+	if err != nil {
+		return []byte(value)
+	}
+	return j
+}
+
+// Fetch retrieves the current version of the secret at the path defined by the key parameter.  If the
+// secret data only contains the "value" field, it is returned as-is, otherwise the secret data is
+// returned as a json object.
+func (b *VaultBackend) Fetch(key string) ([]byte, error) {
+	o := struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}{}
+
+	log.Debugf("reading vault secret %s", key)
+	if err := b.request(http.MethodGet, b.mount+"/data/"+b.path(key), nil, &o); err != nil {
+		return nil, err
+	}
+
+	// a deleted (but not destroyed) secret version returns null data
+	if o.Data.Data == nil {
+		return nil, ErrSecretNotFound
+	}
+
+	if v, ok := o.Data.Data[vaultValueField].(string); ok && len(o.Data.Data) == 1 {
+		return []byte(v), nil
+	}
+
+	return json.Marshal(o.Data.Data)
+}
+
+// List returns the paths of all secrets which start with the provided prefix, walking the folders in the
+// mount below the prefix.  If the prefix starts with a '/', the returned paths will also start with a '/'
+func (b *VaultBackend) List(prefix string) ([]string, error) {
+	lead := ""
+	if strings.HasPrefix(prefix, "/") {
+		lead = "/"
+	}
+
+	p := b.path(prefix)
+	keys := make([]string, 0)
+
+	if err := b.walk(p[:strings.LastIndex(p, "/")+1], func(k string) {
+		if strings.HasPrefix(k, p) {
+			keys = append(keys, lead+k)
+		}
+	}); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Delete soft-deletes the latest version of the secret at the path defined by the key parameter.  The earlier
+// versions and the metadata are kept, so the secret can be recovered using the vault undelete API.
+func (b *VaultBackend) Delete(key string) error {
+	log.Debugf("deleting vault secret %s", key)
+	return b.request(http.MethodDelete, b.mount+"/data/"+b.path(key), nil, nil)
+}
+
+// secret paths are always relative to the mount
+func (b *VaultBackend) path(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+func (b *VaultBackend) walk(dir string, fn func(string)) error {
+	o := struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}{}
+
+	if err := b.request("LIST", b.mount+"/metadata/"+dir, nil, &o); err != nil {
+		if err == ErrSecretNotFound {
+			// vault returns a 404 for an empty folder
+			return nil
+		}
+		return err
+	}
+
+	for _, k := range o.Data.Keys {
+		if strings.HasSuffix(k, "/") {
+			if err := b.walk(dir+k, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		fn(dir + k)
+	}
+
+	return nil
+}
+
+// request calls the vault API at the provided path, sending in as the json body, and decoding the
+// json response in to out.  A 404 response is returned as ErrSecretNotFound
+func (b *VaultBackend) request(method, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", b.addr, path), body)
+	if err != nil {
+		return err
+	}
+
+	if len(b.token) > 0 {
+		req.Header.Set("X-Vault-Token", b.token)
+	}
+
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := b.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrSecretNotFound
+	}
+
+	if res.StatusCode >= 300 {
+		e := struct {
+			Errors []string `json:"errors"`
+		}{}
+		json.NewDecoder(res.Body).Decode(&e)
+
+		return fmt.Errorf("vault returned status %d: %s", res.StatusCode, strings.Join(e.Errors, ", "))
+	}
+
+	if out == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockVaultServer is a minimal stand-in for the Vault KV v2 and AppRole login endpoints
+type mockVaultServer struct {
+	secrets map[string]map[string]interface{}
+	deletes []string
+	token   string
+	mu      sync.Mutex
+}
+
+func newMockVaultServer() (*mockVaultServer, *httptest.Server) {
+	m := &mockVaultServer{secrets: make(map[string]map[string]interface{}), token: "s.mock"}
+	return m, httptest.NewServer(m)
+}
+
+func (m *mockVaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		in := make(map[string]string)
+		json.NewDecoder(r.Body).Decode(&in)
+
+		if in["role_id"] != "role" || in["secret_id"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors": ["invalid role or secret ID"]}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": m.token}})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != m.token {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors": ["permission denied"]}`))
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		p := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+
+		switch r.Method {
+		case http.MethodGet:
+			v, ok := m.secrets[p]
+			if !ok || v == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": v}})
+		case http.MethodPost:
+			in := make(map[string]map[string]interface{})
+			json.NewDecoder(r.Body).Decode(&in)
+			m.secrets[p] = in["data"]
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": 1}})
+		case http.MethodDelete:
+			// a soft delete of the latest version, which keeps the key in the metadata
+			m.deletes = append(m.deletes, r.URL.Path)
+			m.secrets[p] = nil
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		p := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+
+		switch r.Method {
+		case "LIST":
+			keys := make(map[string]bool)
+			for k := range m.secrets {
+				if strings.HasPrefix(k, p) {
+					k = strings.TrimPrefix(k, p)
+					if i := strings.Index(k, "/"); i >= 0 {
+						k = k[:i+1]
+					}
+					keys[k] = true
+				}
+			}
+
+			if len(keys) < 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			out := make([]string, 0)
+			for k := range keys {
+				out = append(out, k)
+			}
+			sort.Strings(out)
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string][]string{"keys": out}})
+		case http.MethodDelete:
+			m.deletes = append(m.deletes, r.URL.Path)
+			delete(m.secrets, p)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestNewVaultBackend(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		b := NewVaultBackend()
+		if b == nil {
+			t.Error("received nil VaultBackend object")
+			return
+		}
+
+		if b.mount != "secret" {
+			t.Errorf("unexpected mount: %s", b.mount)
+		}
+	})
+
+	t.Run("env vars", func(t *testing.T) {
+		os.Setenv("VAULT_ADDR", "https://vault.example.com/")
+		os.Setenv("VAULT_TOKEN", "s.token")
+		defer os.Unsetenv("VAULT_ADDR")
+		defer os.Unsetenv("VAULT_TOKEN")
+
+		b := NewVaultBackend()
+		if b.addr != "https://vault.example.com" || b.token != "s.token" {
+			t.Errorf("unexpected address or token: %s %s", b.addr, b.token)
+		}
+	})
+}
+
+func TestVaultBackend_KmsRequired(t *testing.T) {
+	if NewVaultBackend().KmsRequired() {
+		t.Error("KmsRequired() should be false for VaultBackend")
+	}
+}
+
+func TestVaultBackend_WithMount(t *testing.T) {
+	if b := NewVaultBackend().WithMount("/kv/"); b.mount != "kv" {
+		t.Errorf("unexpected mount: %s", b.mount)
+	}
+}
+
+func TestVaultBackend_WithAppRole(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	t.Run("good", func(t *testing.T) {
+		b := NewVaultBackend()
+		b.addr = srv.URL
+
+		b, err := b.WithAppRole("role", "secret")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if b.token != m.token {
+			t.Errorf("unexpected token: %s", b.token)
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		b := NewVaultBackend()
+		b.addr = srv.URL
+
+		if _, err := b.WithAppRole("role", "wrong"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestVaultBackend_Store(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	b := NewVaultBackend()
+	b.addr = srv.URL
+	b.token = m.token
+
+	t.Run("string", func(t *testing.T) {
+		if err := b.Store("/app/string", "secret"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if m.secrets["app/string"][vaultValueField] != "secret" {
+			t.Errorf("unexpected secret data: %v", m.secrets["app/string"])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		if err := b.Store("app/json", `{"user": "me", "password": "secret"}`); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if m.secrets["app/json"]["password"] != "secret" {
+			t.Errorf("unexpected secret data: %v", m.secrets["app/json"])
+		}
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		if err := b.Store("", "value"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("nil value", func(t *testing.T) {
+		if err := b.Store("my-key", nil); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("empty string", func(t *testing.T) {
+		if err := b.Store("my-key", ""); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		b := NewVaultBackend()
+		b.addr = srv.URL
+		b.token = "s.bad"
+
+		if err := b.Store("my-key", "value"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestVaultBackend_Fetch(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	b := NewVaultBackend()
+	b.addr = srv.URL
+	b.token = m.token
+
+	b.Store("app/string", "secret")
+	b.Store("app/json", `{"user": "me", "password": "secret"}`)
+
+	t.Run("string", func(t *testing.T) {
+		v, err := b.Fetch("/app/string")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "secret" {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		v, err := b.Fetch("app/json")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != `{"password":"secret","user":"me"}` {
+			t.Errorf("unexpected value: %s", v)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := b.Fetch("missing"); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
+}
+
+func TestVaultBackend_ListDelete(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	b := NewVaultBackend()
+	b.addr = srv.URL
+	b.token = m.token
+
+	for _, k := range []string{"app/a", "app/db/b", "application", "other"} {
+		b.Store(k, "v")
+	}
+
+	t.Run("list", func(t *testing.T) {
+		keys, err := b.List("app/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if strings.Join(keys, ",") != "app/a,app/db/b" {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("list leading slash", func(t *testing.T) {
+		keys, err := b.List("/app")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if strings.Join(keys, ",") != "/app/a,/app/db/b,/application" {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("list empty", func(t *testing.T) {
+		keys, err := b.List("nothing/")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(keys) > 0 {
+			t.Errorf("unexpected keys: %v", keys)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := b.Delete("app/a"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := b.Fetch("app/a"); err != ErrSecretNotFound {
+			t.Errorf("secret was not deleted: %v", err)
+		}
+
+		if strings.Join(m.deletes, ",") != "/v1/secret/data/app/a" {
+			t.Errorf("unexpected delete requests: %v", m.deletes)
+		}
+	})
+}
+
+func TestVaultBackend_JsonHandler(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	b := NewVaultBackend()
+	b.addr = srv.URL
+	b.token = m.token
+	sb = b
+
+	if errs := jsonHandler(`{"app/a": "value", "app/b": {"k1": "v1", "k2": "v2"}}`); errs > 0 {
+		t.Error("got an error when storing a known good value")
+		return
+	}
+
+	if m.secrets["app/b"]["k2"] != "v2" {
+		t.Errorf("unexpected secret data: %v", m.secrets["app/b"])
+	}
+}
+
+func TestVaultBackend_Compare(t *testing.T) {
+	m, srv := newMockVaultServer()
+	defer srv.Close()
+
+	b := NewVaultBackend()
+	b.addr = srv.URL
+	b.token = m.token
+
+	for k, v := range map[string]string{
+		"app/string":  "secret",
+		"app/wrapped": `{"value": "secret"}`,
+		"app/json":    `{"user": "me", "password": "secret"}`,
+	} {
+		if err := b.Store(k, v); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if action, _, err := compareSecret(b, k, v); err != nil || action != planUnchanged {
+			t.Errorf("unexpected action for %s: %s %v", k, action, err)
+		}
+	}
+
+	if action, _, _ := compareSecret(b, "app/json", `{"user": "me", "password": "changed"}`); action != planUpdate {
+		t.Errorf("unexpected action for changed value: %s", action)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// profileSetting maps a setting in a config file profile to the option and environment variable it provides a
+// default for
+type profileSetting struct {
+	flag string
+	env  string
+}
+
+// profileSettings are the settings which may be used in a config file profile
+var profileSettings = map[string]profileSetting{
+	"backend":       {"s", "SECRETS_BACKEND"},
+	"table":         {"t", "DYNAMODB_TABLE"},
+	"bucket":        {"b", "S3_BUCKET"},
+	"kms-key":       {"k", "KMS_KEY"},
+	"advanced":      {"a", "SSM_ADVANCED"},
+	"storage-class": {"storage-class", "S3_STORAGE_CLASS"},
+	"region":        {"regions", "SECRETS_REGIONS"},
+	"role-arn":      {"role-arn", "SECRETS_ROLE_ARN"},
+	"external-id":   {"external-id", "SECRETS_EXTERNAL_ID"},
+	"prefix":        {"prefix", "SECRETS_PREFIX"},
+	"key-template":  {"key-template", "KEY_TEMPLATE"},
+	"key-vars":      {"key-var", "KEY_TEMPLATE_VARS"},
+}
+
+// configFile is the format of the config file, which holds the settings of each named profile
+type configFile struct {
+	Profiles map[string]map[string]interface{} `yaml:"profiles"`
+}
+
+// defaultConfigFile returns the path of the config file used when the -config option is not set
+func defaultConfigFile() string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(d, "aws-secrets-sync", "config.yaml")
+}
+
+// loadProfile reads the named profile from the config file
+func loadProfile(path, name string) (map[string]interface{}, error) {
+	if len(path) < 1 {
+		return nil, fmt.Errorf("unable to find the config file for profile %s, use -config to set the path", name)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := new(configFile)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s not found in config file %s", name, path)
+	}
+	return p, nil
+}
+
+// applyProfile sets the options in fs using the profile settings, unless the option was set on the command line
+// or using its environment variable.  This gives the precedence flag > env > profile > defaults.
+func applyProfile(fs *flag.FlagSet, p map[string]interface{}) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	names := make([]string, 0, len(p))
+	for k := range p {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		s, ok := profileSettings[k]
+		if !ok {
+			return fmt.Errorf("unknown profile setting %s", k)
+		}
+
+		// an empty environment variable is treated as unset, the same as the option defaults
+		if len(os.Getenv(s.env)) > 0 || set[s.flag] {
+			log.Debugf("profile setting %s overridden by -%s or %s", k, s.flag, s.env)
+			continue
+		}
+
+		v := fmt.Sprint(p[k])
+		switch t := p[k].(type) {
+		case []interface{}:
+			// lists, like the regions, are set as a comma-separated list
+			parts := make([]string, len(t))
+			for i := range t {
+				parts[i] = fmt.Sprint(t[i])
+			}
+			v = strings.Join(parts, ",")
+		case map[interface{}]interface{}:
+			// maps, like the key template variables, are set as a comma-separated list of name=value pairs
+			m := make(mapValue)
+			for n, x := range t {
+				m[fmt.Sprint(n)] = fmt.Sprint(x)
+			}
+			v = m.String()
+		}
+
+		if err := fs.Set(s.flag, v); err != nil {
+			return fmt.Errorf("invalid profile setting %s: %v", k, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+profiles:
+  prod:
+    backend: dynamodb
+    table: my-table
+    kms-key: alias/my/key
+    advanced: true
+    region: [us-east-1, us-west-2]
+`
+
+func TestLoadProfile(t *testing.T) {
+	d, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(d)
+
+	path := filepath.Join(d, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0600); err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Run("good", func(t *testing.T) {
+		p, err := loadProfile(path, "prod")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if p["table"] != "my-table" || p["advanced"] != true {
+			t.Errorf("unexpected profile: %v", p)
+		}
+	})
+
+	t.Run("missing profile", func(t *testing.T) {
+		if _, err := loadProfile(path, "dev"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := loadProfile(filepath.Join(d, "missing.yaml"), "prod"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad file", func(t *testing.T) {
+		bad := filepath.Join(d, "bad.yaml")
+		if err := ioutil.WriteFile(bad, []byte("profiles: [prod]"), 0600); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := loadProfile(bad, "prod"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestApplyProfile(t *testing.T) {
+	var backend, table, key, regions string
+	var advanced bool
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&backend, "s", "", "")
+	fs.StringVar(&table, "t", "", "")
+	fs.StringVar(&key, "k", "", "")
+	fs.StringVar(&regions, "regions", "", "")
+	fs.BoolVar(&advanced, "a", false, "")
+
+	t.Run("precedence", func(t *testing.T) {
+		os.Setenv("KMS_KEY", "alias/env/key")
+		defer os.Unsetenv("KMS_KEY")
+		key = "alias/env/key"
+
+		if err := fs.Parse([]string{"-s", "ssm"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		p := map[string]interface{}{"backend": "dynamodb", "table": "my-table", "kms-key": "alias/my/key",
+			"advanced": true, "region": []interface{}{"us-east-1", "us-west-2"}}
+		if err := applyProfile(fs, p); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if backend != "ssm" {
+			t.Errorf("command line option overridden: %s", backend)
+		}
+
+		if key != "alias/env/key" {
+			t.Errorf("environment variable overridden: %s", key)
+		}
+
+		if table != "my-table" || !advanced || regions != "us-east-1,us-west-2" {
+			t.Errorf("profile settings not applied: %s %v %s", table, advanced, regions)
+		}
+	})
+
+	t.Run("empty environment variable", func(t *testing.T) {
+		os.Setenv("KMS_KEY", "")
+		defer os.Unsetenv("KMS_KEY")
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.StringVar(&key, "k", "", "")
+
+		if err := applyProfile(fs, map[string]interface{}{"kms-key": "alias/my/key"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if key != "alias/my/key" {
+			t.Errorf("profile setting not applied: %s", key)
+		}
+	})
+
+	t.Run("key vars", func(t *testing.T) {
+		vars := make(mapValue)
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(vars, "key-var", "")
+
+		p := map[string]interface{}{"key-vars": map[interface{}]interface{}{"env": "prod", "service": "app"}}
+		if err := applyProfile(fs, p); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if vars.String() != "env=prod,service=app" {
+			t.Errorf("unexpected key template variables: %s", vars)
+		}
+	})
+
+	t.Run("unknown setting", func(t *testing.T) {
+		if err := applyProfile(fs, map[string]interface{}{"tabel": "my-table"}); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad value", func(t *testing.T) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.BoolVar(&advanced, "a", false, "")
+
+		if err := applyProfile(fs, map[string]interface{}{"advanced": "maybe"}); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// endpoints holds the custom endpoint URL for each AWS service, keyed by the service endpoint ID.  Services
+// without a custom endpoint use the default endpoint for the region.
+var endpoints = make(map[string]string)
+
+// setEndpoints validates the custom endpoint URLs, and sets them as the endpoints used by the AWS clients created
+// after the call.  Empty URLs are ignored.
+func setEndpoints(m map[string]string) error {
+	e := make(map[string]string)
+	for svc, v := range m {
+		if len(v) < 1 {
+			continue
+		}
+
+		u, err := url.Parse(v)
+		if err != nil || len(u.Scheme) < 1 || len(u.Host) < 1 {
+			return fmt.Errorf("invalid %s endpoint %s, must be a URL like https://host:port", svc, v)
+		}
+
+		log.Debugf("using endpoint %s for %s", v, svc)
+		e[svc] = v
+	}
+
+	endpoints = e
+	return nil
+}
+
+// endpointConfig returns the config for a client of the AWS service, which sets the custom endpoint of the
+// service, if any.  S3 clients using a custom endpoint use path-style addressing, since bucket names are
+// usually not resolvable as sub-domains of LocalStack or VPC endpoint host names.
+func endpointConfig(svc string) *aws.Config {
+	c := aws.NewConfig()
+
+	if v, ok := endpoints[svc]; ok {
+		c.WithEndpoint(v)
+
+		if svc == s3.EndpointsID {
+			c.WithS3ForcePathStyle(true)
+		}
+	}
+
+	return c
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestSetEndpoints(t *testing.T) {
+	defer setEndpoints(nil)
+
+	t.Run("good", func(t *testing.T) {
+		m := map[string]string{ssm.EndpointsID: "http://localhost:4566", kms.EndpointsID: ""}
+		if err := setEndpoints(m); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(endpoints) != 1 || endpoints[ssm.EndpointsID] != "http://localhost:4566" {
+			t.Errorf("unexpected endpoints: %v", endpoints)
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		for _, v := range []string{"localhost:4566", "localhost", "http://", "://x"} {
+			if err := setEndpoints(map[string]string{ssm.EndpointsID: v}); err == nil {
+				t.Errorf("did not receive expected error for %s", v)
+			}
+		}
+	})
+}
+
+func TestEndpointConfig(t *testing.T) {
+	url := "http://localhost:4566"
+	if err := setEndpoints(map[string]string{dynamodb.EndpointsID: url, kms.EndpointsID: url, s3.EndpointsID: url,
+		secretsmanager.EndpointsID: url, ssm.EndpointsID: url}); err != nil {
+		t.Error(err)
+		return
+	}
+	defer setEndpoints(nil)
+
+	t.Run("clients", func(t *testing.T) {
+		s3b := NewS3Backend()
+		ddb := NewDynamoDbBackend()
+
+		for svc, e := range map[string]string{
+			ssm.EndpointsID:            NewParameterStoreBackend().c.(*ssm.SSM).Endpoint,
+			secretsmanager.EndpointsID: NewSecretsManagerBackend().WithSession(ses).c.(*secretsmanager.SecretsManager).Endpoint,
+			dynamodb.EndpointsID:       ddb.c.(*dynamodb.DynamoDB).Endpoint,
+			"dynamodb kms":             ddb.k.(*kms.KMS).Endpoint,
+			s3.EndpointsID:             s3b.s.(*s3.S3).Endpoint,
+			"s3 kms":                   s3b.k.Endpoint,
+		} {
+			if e != url {
+				t.Errorf("unexpected %s endpoint: %s", svc, e)
+			}
+		}
+
+		if !aws.BoolValue(s3b.s.(*s3.S3).Config.S3ForcePathStyle) {
+			t.Error("path-style addressing not used for s3")
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		c := endpointConfig("sts")
+		if c.Endpoint != nil || c.S3ForcePathStyle != nil {
+			t.Errorf("unexpected config: %v", c)
+		}
+	})
+
+	t.Run("s3 path-style", func(t *testing.T) {
+		var path string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Write([]byte("shhhh"))
+		}))
+		defer srv.Close()
+
+		if err := setEndpoints(map[string]string{s3.EndpointsID: srv.URL}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		s := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1").
+			WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+
+		v, err := NewS3Backend().WithSession(s).WithBucket("my-bucket").Fetch("my/secret")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(v) != "shhhh" || path != "/my-bucket/my/secret" {
+			t.Errorf("unexpected request path %s", path)
+		}
+	})
+}
@@ -1,8 +1,12 @@
 module aws-secrets-sync
 
-go 1.13
+go 1.20
 
 require (
 	github.com/aws/aws-sdk-go v1.34.0
 	github.com/mmmorris1975/simple-logger v0.4.0
+	golang.org/x/crypto v0.31.0
+	gopkg.in/yaml.v2 v2.4.0
 )
+
+require github.com/jmespath/go-jmespath v0.3.0 // indirect
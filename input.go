@@ -0,0 +1,735 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON       = "json"
+	formatYAML       = "yaml"
+	formatDotenv     = "dotenv"
+	formatProperties = "properties"
+)
+
+const (
+	flattenArraysJSON  = "json"
+	flattenArraysIndex = "index"
+)
+
+// the reserved input document key which holds the per-key tags, it is never stored as a secret
+const tagsKey = "_tags"
+
+// the reserved input document key which selects the schema of the document, it is never stored as a secret
+const schemaKey = "_schema"
+
+const (
+	schemaSimple = "simple"
+	schemaRecord = "record"
+)
+
+// the fields of a secret record, used with the record input schema
+const (
+	fieldValue       = "value"
+	fieldDescription = "description"
+	fieldTags        = "tags"
+	fieldKmsKey      = "kms_key"
+	fieldTier        = "tier"
+	fieldContentType = "content_type"
+)
+
+var formats = sort.StringSlice{formatJSON, formatYAML, formatDotenv, formatProperties}
+
+// secret is a single key and value decoded from the program input, along with any metadata for the key.
+// Only the tags can be set using the simple schema, the other metadata requires the record schema.
+type secret struct {
+	key         string
+	value       string
+	description string
+	tags        map[string]string
+	kmsKey      string
+	tier        string
+	contentType string
+}
+
+// fields returns the names of the metadata fields which are set for the secret
+func (s *secret) fields() []string {
+	f := make([]string, 0)
+	if len(s.description) > 0 {
+		f = append(f, fieldDescription)
+	}
+	if len(s.tags) > 0 {
+		f = append(f, fieldTags)
+	}
+	if len(s.kmsKey) > 0 {
+		f = append(f, fieldKmsKey)
+	}
+	if len(s.tier) > 0 {
+		f = append(f, fieldTier)
+	}
+	if len(s.contentType) > 0 {
+		f = append(f, fieldContentType)
+	}
+	return f
+}
+
+// decodeInput reads the input provided by getReader() using the format set by the -format option,
+// returning the secrets in the order they were read.  Since maps are unordered, the keys within each
+// document are sorted.  A key repeated in multiple documents is returned once, using the last value read.  Non-string values are re-encoded as json, so nested data is stored as a single value.
+// The tags for each key are taken from the reserved "_tags" map of the document, see inputTags().  Documents
+// using the record schema (see inputSchema()) are decoded using decodeRecords().
+func decodeInput(in interface{}) ([]*secret, error) {
+	r := getReader(in)
+	if r == nil {
+		return nil, fmt.Errorf("received a nil reader to handle %s input, something has gone very wrong", inputFormat())
+	}
+
+	var docs []map[string]interface{}
+	var err error
+
+	switch inputFormat() {
+	case formatJSON:
+		docs, err = decodeJSON(r)
+	case formatYAML:
+		docs, err = decodeYAML(r)
+	case formatDotenv:
+		docs, err = decodeDotenv(r)
+	case formatProperties:
+		docs, err = decodeProperties(r)
+	default:
+		return nil, fmt.Errorf("input format %s is not valid, must be one of: %s", formatArg, strings.Join(formats, ", "))
+	}
+
+	if err != nil {
+		// bad input, should probably not continue
+		return nil, fmt.Errorf("error decoding %s: %v", inputFormat(), err)
+	}
+
+	tags := make(map[string]map[string]string)
+	secrets := make([]*secret, 0)
+	seen := make(map[string]int)
+
+	for _, m := range docs {
+		if err := inputTags(m, tags); err != nil {
+			return nil, err
+		}
+
+		schema, err := inputSchema(m)
+		if err != nil {
+			return nil, err
+		}
+
+		var s []*secret
+		if schema == schemaRecord {
+			s, err = decodeRecords(m)
+		} else {
+			s, err = decodeValues(m)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		// a key repeated in a later document replaces the earlier secret, so the last value read is stored, even
+		// when the secrets are stored concurrently
+		for _, v := range s {
+			if i, ok := seen[v.key]; ok {
+				secrets[i] = v
+				continue
+			}
+
+			seen[v.key] = len(secrets)
+			secrets = append(secrets, v)
+		}
+	}
+
+	for _, s := range secrets {
+		if t, ok := tags[s.key]; ok {
+			s.tags = mergeTags(s.tags, t)
+			delete(tags, s.key)
+		}
+	}
+
+	// catch typos in the tagged key names, instead of silently storing the secret without the tags
+	for k := range tags {
+		return nil, fmt.Errorf("tags provided for key %s, which is not in the input", k)
+	}
+
+	return secrets, nil
+}
+
+// decodeValues returns the secrets in a simple schema document, which is a map of key names to values
+func decodeValues(m map[string]interface{}) ([]*secret, error) {
+	if flattenArg {
+		f := make(map[string]interface{})
+		for k, v := range m {
+			if err := flatten(k, v, f); err != nil {
+				return nil, err
+			}
+		}
+		m = f
+	}
+
+	secrets := make([]*secret, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		v, err := stringValue(m[k])
+		if err != nil {
+			return nil, fmt.Errorf("error encoding value for %s: %v", k, err)
+		}
+		secrets = append(secrets, &secret{key: k, value: v})
+	}
+
+	return secrets, nil
+}
+
+// decodeRecords returns the secrets in a record schema document, which is a map of key names to secret records.
+// A secret record is a map with the required "value" field, and the optional "description", "tags", "kms_key",
+// "tier", and "content_type" fields, for example:
+//
+//	{"_schema": "record", "my-key": {"value": "secret", "description": "my secret", "tags": {"team": "ops"}}}
+//
+// Unknown fields are an error, to catch typos in the field names.
+func decodeRecords(m map[string]interface{}) ([]*secret, error) {
+	if flattenArg {
+		return nil, fmt.Errorf("-flatten can not be used with the %s input schema", schemaRecord)
+	}
+
+	secrets := make([]*secret, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		rec, ok := m[k].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("value for %s must be a secret record", k)
+		}
+
+		if _, ok := rec[fieldValue]; !ok {
+			return nil, fmt.Errorf("secret record for %s is missing the %s field", k, fieldValue)
+		}
+
+		s := &secret{key: k}
+		for f, v := range rec {
+			var err error
+
+			switch f {
+			case fieldValue:
+				s.value, err = stringValue(v)
+			case fieldDescription:
+				s.description, err = stringField(v)
+			case fieldKmsKey:
+				s.kmsKey, err = stringField(v)
+			case fieldTier:
+				s.tier, err = tierField(v)
+			case fieldContentType:
+				s.contentType, err = stringField(v)
+			case fieldTags:
+				s.tags, err = tagsField(v)
+			default:
+				err = fmt.Errorf("unknown field")
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s field in secret record for %s: %v", f, k, err)
+			}
+		}
+		secrets = append(secrets, s)
+	}
+
+	return secrets, nil
+}
+
+// inputSchema removes the reserved "_schema" key from the document, and returns the schema of the document.
+// If the document does not set a schema, the -schema option is used.
+func inputSchema(doc map[string]interface{}) (string, error) {
+	schema := strings.ToLower(schemaArg)
+
+	if v, ok := doc[schemaKey]; ok {
+		delete(doc, schemaKey)
+
+		s, ok := v.(string)
+		if !ok {
+			return "", fmt.Errorf("%s must be a string", schemaKey)
+		}
+		schema = strings.ToLower(s)
+	}
+
+	switch schema {
+	case "", schemaSimple:
+		return schemaSimple, nil
+	case schemaRecord:
+		return schemaRecord, nil
+	}
+	return "", fmt.Errorf("input schema %s is not valid, must be one of: %s, %s", schema, schemaSimple, schemaRecord)
+}
+
+// strings are used as-is, any other value is encoded as json
+func stringValue(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	jv, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(jv), nil
+}
+
+func stringField(v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("must be a string")
+	}
+	return s, nil
+}
+
+// the SSM parameter tier, which is checked when decoding so that a typo rejects the input before any secrets
+// are stored.  The tier name is not case-sensitive, and is returned using the name expected by the SSM API.
+func tierField(v interface{}) (string, error) {
+	s, err := stringField(v)
+	if err != nil {
+		return "", err
+	}
+
+	tiers := []string{ssm.ParameterTierStandard, ssm.ParameterTierAdvanced, ssm.ParameterTierIntelligentTiering}
+	for _, t := range tiers {
+		if strings.EqualFold(s, t) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("tier %s is not valid, must be one of: %s", s, strings.Join(tiers, ", "))
+}
+
+// a map of tag keys and single values, which are converted to strings
+func tagsField(v interface{}) (map[string]string, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a map of tag keys and values")
+	}
+
+	tags := make(map[string]string, len(m))
+	for k, v := range m {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}, nil:
+			return nil, fmt.Errorf("tag %s must be a single value", k)
+		}
+		tags[k] = fmt.Sprint(v)
+	}
+	return tags, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// inputTags removes the reserved "_tags" key from the document, adding its contents to tags.  The value
+// must be a map of secret key names to a map of tag keys and values, for example:
+//
+//	{"_tags": {"my-key": {"team": "ops"}}, "my-key": "secret"}
+//
+// When used with -flatten, the tags must use the flattened key name.
+func inputTags(doc map[string]interface{}, tags map[string]map[string]string) error {
+	v, ok := doc[tagsKey]
+	if !ok {
+		return nil
+	}
+	delete(doc, tagsKey)
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a map of key names to tags", tagsKey)
+	}
+
+	for k, v := range m {
+		t, err := tagsField(v)
+		if err != nil {
+			return fmt.Errorf("invalid tags for key %s: %v", k, err)
+		}
+		tags[k] = mergeTags(tags[k], t)
+	}
+
+	return nil
+}
+
+// validateFlatten checks the -flatten-arrays option, so that an invalid value is reported even if the input has
+// no arrays
+func validateFlatten() error {
+	switch strings.ToLower(flattenArrArg) {
+	case "", flattenArraysJSON, flattenArraysIndex:
+		return nil
+	}
+	return fmt.Errorf("array handling %s is not valid, must be one of: %s, %s", flattenArrArg, flattenArraysJSON, flattenArraysIndex)
+}
+
+// flatten adds the leaf values of v to out, using the path of map keys (and array indexes, if enabled)
+// joined with the -flatten-sep separator as the key.  If the parent key already ends with the separator,
+// it will not be repeated.
+func flatten(key string, v interface{}, out map[string]interface{}) error {
+	sep := flattenSepArg
+	if len(sep) < 1 {
+		sep = "/"
+	}
+
+	join := func(k string) string {
+		if strings.HasSuffix(key, sep) {
+			return key + k
+		}
+		return key + sep + k
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) < 1 {
+			log.Warnf("ignoring empty nested value for %s, which has no keys to flatten", key)
+			return nil
+		}
+
+		for k, v := range t {
+			if err := flatten(join(k), v, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		switch strings.ToLower(flattenArrArg) {
+		case "", flattenArraysJSON:
+			// arrays are leaf values, so fall through and store it
+		case flattenArraysIndex:
+			for i, v := range t {
+				if err := flatten(join(strconv.Itoa(i)), v, out); err != nil {
+					return err
+				}
+			}
+			return nil
+		default:
+			return fmt.Errorf("array handling %s is not valid, must be one of: %s, %s", flattenArrArg, flattenArraysJSON, flattenArraysIndex)
+		}
+	}
+
+	if _, ok := out[key]; ok {
+		return fmt.Errorf("duplicate key %s found when flattening input", key)
+	}
+	out[key] = v
+
+	return nil
+}
+
+// json is the default, for compatibility with versions of the tool before the -format option was added
+func inputFormat() string {
+	if len(formatArg) < 1 {
+		return formatJSON
+	}
+	return strings.ToLower(formatArg)
+}
+
+// decode a stream of json objects
+func decodeJSON(r io.Reader) ([]map[string]interface{}, error) {
+	docs := make([]map[string]interface{}, 0)
+
+	j := json.NewDecoder(r)
+	for {
+		m := make(map[string]interface{})
+		if err := j.Decode(&m); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		docs = append(docs, m)
+	}
+
+	return docs, nil
+}
+
+// decode a stream of yaml documents, each of which must be a map
+func decodeYAML(r io.Reader) ([]map[string]interface{}, error) {
+	docs := make([]map[string]interface{}, 0)
+
+	y := yaml.NewDecoder(r)
+	for {
+		var v interface{}
+		if err := y.Decode(&v); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		// an empty document
+		if v == nil {
+			continue
+		}
+
+		m, ok := yamlToJSON(v).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("yaml document is not a map")
+		}
+		docs = append(docs, m)
+	}
+
+	return docs, nil
+}
+
+// the yaml decoder creates map[interface{}]interface{} values for nested maps, which json can not encode
+func yamlToJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = yamlToJSON(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range t {
+			t[i] = yamlToJSON(v)
+		}
+		return t
+	}
+	return v
+}
+
+// decodeDotenv reads KEY=value lines, ignoring blank lines and lines starting with '#'.  An optional
+// 'export ' prefix is ignored.  Values in single quotes are used literally, values in double quotes
+// support the \n, \r, \t, \", \\ and \$ escapes, and both may span multiple lines.  Unquoted values have
+// surrounding whitespace and any trailing ' #' comment removed.  Variable expansion is not supported.
+func decodeDotenv(r io.Reader) ([]map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(lines[n])
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		}
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n+1)
+		}
+
+		k := strings.TrimSpace(line[:i])
+		if strings.ContainsAny(k, " \t") {
+			return nil, fmt.Errorf("line %d: invalid key %q", n+1, k)
+		}
+		v := strings.TrimSpace(line[i+1:])
+
+		if len(v) > 0 && (v[0] == '\'' || v[0] == '"') {
+			q := v[0]
+			start := n
+
+			// keep consuming lines until we find the closing quote
+			for {
+				end := closingQuote(v, q)
+				if end > 0 {
+					rest := strings.TrimSpace(v[end+1:])
+					if len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+						return nil, fmt.Errorf("line %d: unexpected characters after quoted value", n+1)
+					}
+					v = v[1:end]
+					break
+				}
+
+				n++
+				if n >= len(lines) {
+					return nil, fmt.Errorf("line %d: unterminated quoted value", start+1)
+				}
+				v += "\n" + lines[n]
+			}
+
+			if q == '"' {
+				v = unescapeDotenv(v)
+			}
+		} else if i := strings.Index(v, " #"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+
+		m[k] = v
+	}
+
+	return []map[string]interface{}{m}, nil
+}
+
+// find the index of the unescaped closing quote character, or -1 if not found
+func closingQuote(v string, q byte) int {
+	for i := 1; i < len(v); i++ {
+		switch {
+		case v[i] == '\\' && q == '"':
+			i++
+		case v[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(v string) string {
+	b := new(strings.Builder)
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i == len(v)-1 {
+			b.WriteByte(v[i])
+			continue
+		}
+
+		i++
+		switch v[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(v[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
+// decodeProperties reads a Java .properties file, following the rules of java.util.Properties.load().
+// Lines starting with '#' or '!' are comments, the key ends at the first unescaped '=', ':' or whitespace,
+// lines ending in an odd number of '\' characters continue on the next line, and the \t, \n, \r, \f and
+// \uXXXX escapes are supported.  Unlike Java, the input is read as UTF-8.
+func decodeProperties(r io.Reader) ([]map[string]interface{}, error) {
+	m := make(map[string]interface{})
+
+	lines, err := readLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimLeft(lines[n], " \t\f")
+		if len(line) < 1 || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		start := n
+		for continued(line) && n+1 < len(lines) {
+			n++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[n], " \t\f")
+		}
+
+		if continued(line) {
+			line = line[:len(line)-1]
+		}
+
+		// find the end of the key
+		i := 0
+		for ; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+				continue
+			}
+
+			if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+				break
+			}
+		}
+
+		if i > len(line) {
+			i = len(line)
+		}
+
+		k, err := unescapeProperties(line[:i])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", start+1, err)
+		}
+
+		// skip whitespace, then a single separator character, then more whitespace
+		v := strings.TrimLeft(line[i:], " \t\f")
+		if len(v) > 0 && (v[0] == '=' || v[0] == ':') {
+			v = strings.TrimLeft(v[1:], " \t\f")
+		}
+
+		if m[k], err = unescapeProperties(v); err != nil {
+			return nil, fmt.Errorf("line %d: %v", start+1, err)
+		}
+	}
+
+	return []map[string]interface{}{m}, nil
+}
+
+// a properties line is continued if it ends with an odd number of backslashes
+func continued(line string) bool {
+	c := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		c++
+	}
+	return c%2 == 1
+}
+
+func unescapeProperties(v string) (string, error) {
+	b := new(strings.Builder)
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' || i == len(v)-1 {
+			b.WriteByte(v[i])
+			continue
+		}
+
+		i++
+		switch v[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(v) {
+				return "", fmt.Errorf("malformed \\uXXXX escape")
+			}
+
+			c, err := strconv.ParseUint(v[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uXXXX escape")
+			}
+			b.WriteRune(rune(c))
+			i += 4
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// read all lines of the input, handling both \n and \r\n line endings
+func readLines(r io.Reader) ([]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+
+	return lines, s.Err()
+}
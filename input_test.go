@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// join the decoded secrets as key=value lines, for easy comparison
+func joinSecrets(s []*secret) string {
+	lines := make([]string, 0, len(s))
+	for _, v := range s {
+		lines = append(lines, v.key+"="+v.value)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDecodeInput(t *testing.T) {
+	t.Run("multiple objects", func(t *testing.T) {
+		s, err := decodeInput(`{"b": "2", "a": "1"} {"c": {"k": "v"}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(s) != 3 {
+			t.Errorf("unexpected number of secrets: %d", len(s))
+			return
+		}
+
+		if s[0].key != "a" || s[1].key != "b" || s[2].key != "c" {
+			t.Errorf("unexpected key order: %s %s %s", s[0].key, s[1].key, s[2].key)
+		}
+
+		if s[2].value != `{"k":"v"}` {
+			t.Errorf("unexpected nested value: %s", s[2].value)
+		}
+	})
+
+	t.Run("repeated key", func(t *testing.T) {
+		s, err := decodeInput(`{"a": "1", "b": "2"} {"a": "3"}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "a=3\nb=2" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("bad json", func(t *testing.T) {
+		if _, err := decodeInput(`{"a": "1"} not json`); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDecodeInput_YAML(t *testing.T) {
+	formatArg = formatYAML
+	defer func() { formatArg = "" }()
+
+	t.Run("good", func(t *testing.T) {
+		y := "b: value b\na:\n  user: me\n  ports: [1, 2]\nc: 5\n---\nd: \"multi\\nline\"\n"
+		s, err := decodeInput(y)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := `a={"ports":[1,2],"user":"me"}` + "\nb=value b\nc=5\nd=multi\nline"
+		if joinSecrets(s) != expected {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("base64 gzip", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		gz := gzip.NewWriter(b)
+		gz.Write([]byte("key: value\n"))
+		gz.Close()
+
+		s, err := decodeInput(base64.StdEncoding.EncodeToString(b.Bytes()))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "key=value" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("not a map", func(t *testing.T) {
+		if _, err := decodeInput("- a\n- b\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad yaml", func(t *testing.T) {
+		if _, err := decodeInput("a: [b\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDecodeInput_Dotenv(t *testing.T) {
+	formatArg = formatDotenv
+	defer func() { formatArg = "" }()
+
+	t.Run("good", func(t *testing.T) {
+		env := `# a comment
+PLAIN=value
+export EXPORTED=exported value # with a comment
+SINGLE='literal \n $HOME'
+DOUBLE="escaped\tvalue \"quoted\""
+EMPTY=
+
+MULTI="line 1
+line 2"
+HASH=abc#def
+`
+		s, err := decodeInput(env)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := "DOUBLE=escaped\tvalue \"quoted\"\nEMPTY=\nEXPORTED=exported value\nHASH=abc#def\n" +
+			"MULTI=line 1\nline 2\nPLAIN=value\nSINGLE=literal \\n $HOME"
+		if joinSecrets(s) != expected {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("missing equals", func(t *testing.T) {
+		if _, err := decodeInput("KEY value\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("unterminated quote", func(t *testing.T) {
+		if _, err := decodeInput("KEY=\"value\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("trailing garbage", func(t *testing.T) {
+		if _, err := decodeInput("KEY='value' extra\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDecodeInput_Properties(t *testing.T) {
+	formatArg = formatProperties
+	defer func() { formatArg = "" }()
+
+	t.Run("good", func(t *testing.T) {
+		p := `# comment
+! also a comment
+equals = value 1
+colon:value 2
+space value 3
+escaped\ key=value\t4
+unicode=caf\u00e9
+continued=first, \
+          second
+empty
+`
+		s, err := decodeInput(p)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := "colon=value 2\ncontinued=first, second\nempty=\nequals=value 1\nescaped key=value\t4\n" +
+			"space=value 3\nunicode=café"
+		if joinSecrets(s) != expected {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("bad unicode", func(t *testing.T) {
+		if _, err := decodeInput("key=\\u00zz\n"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestDecodeInput_BadFormat(t *testing.T) {
+	formatArg = "xml"
+	defer func() { formatArg = "" }()
+
+	if _, err := decodeInput("<xml/>"); err == nil {
+		t.Error("did not receive expected error")
+	}
+}
+
+func TestDecodeInput_Flatten(t *testing.T) {
+	flattenArg = true
+	defer func() {
+		flattenArg = false
+		flattenSepArg = ""
+		flattenArrArg = ""
+	}()
+
+	j := `{"/app": {"db": {"password": "x", "port": 5432}, "hosts": ["a", "b"]}, "top": "level"}`
+
+	t.Run("default", func(t *testing.T) {
+		s, err := decodeInput(j)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := "/app/db/password=x\n/app/db/port=5432\n/app/hosts=[\"a\",\"b\"]\ntop=level"
+		if joinSecrets(s) != expected {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("index arrays", func(t *testing.T) {
+		flattenArrArg = flattenArraysIndex
+		defer func() { flattenArrArg = "" }()
+
+		s, err := decodeInput(`{"/app/": {"hosts": ["a", {"b": "c"}]}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "/app/hosts/0=a\n/app/hosts/1/b=c" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("separator", func(t *testing.T) {
+		flattenSepArg = "."
+		defer func() { flattenSepArg = "" }()
+
+		s, err := decodeInput(`{"app": {"db": {"password": "x"}}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "app.db.password=x" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		if _, err := decodeInput(`{"/a/b": "x", "/a": {"b": "y"}}`); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad array handling", func(t *testing.T) {
+		flattenArrArg = "bogus"
+		defer func() { flattenArrArg = "" }()
+
+		if _, err := decodeInput(j); err == nil {
+			t.Error("did not receive expected error")
+		}
+
+		if err := validateFlatten(); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("empty map", func(t *testing.T) {
+		s, err := decodeInput(`{"/app": {"db": {}, "user": "me"}, "/empty": {}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "/app/user=me" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+		}
+	})
+}
+
+func TestValidateFlatten(t *testing.T) {
+	defer func() { flattenArrArg = "" }()
+
+	for _, v := range []string{"", flattenArraysJSON, flattenArraysIndex, "INDEX"} {
+		flattenArrArg = v
+		if err := validateFlatten(); err != nil {
+			t.Errorf("unexpected error for %s: %v", v, err)
+		}
+	}
+}
+
+func TestDecodeInput_Tags(t *testing.T) {
+	t.Run("good", func(t *testing.T) {
+		s, err := decodeInput(`{"_tags": {"b": {"team": "ops", "version": 2}}, "a": "1", "b": "2"}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "a=1\nb=2" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+			return
+		}
+
+		if s[0].tags != nil || s[1].tags["team"] != "ops" || s[1].tags["version"] != "2" {
+			t.Errorf("unexpected tags: %v %v", s[0].tags, s[1].tags)
+		}
+	})
+
+	t.Run("flattened", func(t *testing.T) {
+		flattenArg = true
+		defer func() { flattenArg = false }()
+
+		s, err := decodeInput(`{"_tags": {"/app/db": {"team": "ops"}}, "/app": {"db": "x"}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if s[0].tags["team"] != "ops" {
+			t.Errorf("unexpected tags: %v", s[0].tags)
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		if _, err := decodeInput(`{"_tags": {"c": {"team": "ops"}}, "a": "1"}`); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("bad tags", func(t *testing.T) {
+		for _, j := range []string{`{"_tags": "x"}`, `{"_tags": {"a": "x"}, "a": "1"}`, `{"_tags": {"a": {"t": {"n": "x"}}}, "a": "1"}`} {
+			if _, err := decodeInput(j); err == nil {
+				t.Errorf("did not receive expected error for %s", j)
+			}
+		}
+	})
+}
+
+func TestDecodeInput_Records(t *testing.T) {
+	j := `{"b": {"value": "2", "description": "b secret", "tags": {"team": "ops"}, "kms_key": "alias/b", "tier": "Advanced", "content_type": "text/plain"},
+	       "a": {"value": {"user": "me"}}}`
+
+	t.Run("flag", func(t *testing.T) {
+		schemaArg = schemaRecord
+		defer func() { schemaArg = "" }()
+
+		s, err := decodeInput(j)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "a={\"user\":\"me\"}\nb=2" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+			return
+		}
+
+		if len(s[0].fields()) > 0 {
+			t.Errorf("unexpected metadata: %v", s[0].fields())
+		}
+
+		b := s[1]
+		if b.description != "b secret" || b.tags["team"] != "ops" || b.kmsKey != "alias/b" || b.tier != "Advanced" || b.contentType != "text/plain" {
+			t.Errorf("unexpected record: %+v", b)
+		}
+	})
+
+	t.Run("marker", func(t *testing.T) {
+		s, err := decodeInput(`{"a": "simple"} {"_schema": "record", "_tags": {"b": {"env": "dev"}}, "b": {"value": "2", "tags": {"team": "ops"}}}`)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if joinSecrets(s) != "a=simple\nb=2" {
+			t.Errorf("unexpected secrets:\n%s", joinSecrets(s))
+			return
+		}
+
+		if len(s[1].tags) != 2 {
+			t.Errorf("unexpected tags: %v", s[1].tags)
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		tests := []string{
+			`{"_schema": "bogus", "a": "1"}`,
+			`{"_schema": 1, "a": "1"}`,
+			`{"_schema": "record", "a": "1"}`,
+			`{"_schema": "record", "a": {"description": "no value"}}`,
+			`{"_schema": "record", "a": {"value": "1", "descripton": "typo"}}`,
+			`{"_schema": "record", "a": {"value": "1", "tier": 1}}`,
+			`{"_schema": "record", "a": {"value": "1", "tier": "Advnaced"}}`,
+			`{"_schema": "record", "a": {"value": "1", "tags": ["x"]}}`,
+		}
+
+		for _, j := range tests {
+			if _, err := decodeInput(j); err == nil {
+				t.Errorf("did not receive expected error for %s", j)
+			}
+		}
+	})
+
+	t.Run("flatten", func(t *testing.T) {
+		flattenArg = true
+		defer func() { flattenArg = false }()
+
+		if _, err := decodeInput(`{"_schema": "record", "a": {"value": "1"}}`); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestTierField(t *testing.T) {
+	for in, want := range map[string]string{"Standard": "Standard", "advanced": "Advanced", "INTELLIGENT-TIERING": "Intelligent-Tiering"} {
+		if v, err := tierField(in); err != nil || v != want {
+			t.Errorf("unexpected tier for %s: %s %v", in, v, err)
+		}
+	}
+
+	for _, v := range []interface{}{"Premium", "", 1} {
+		if _, err := tierField(v); err == nil {
+			t.Errorf("did not receive expected error for %v", v)
+		}
+	}
+}
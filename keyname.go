@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// the template variable replaced by the key name from the input
+const keyVar = "key"
+
+// templateVar matches the {{name}} variables in a key template
+var templateVar = regexp.MustCompile(`{{\s*([A-Za-z0-9_-]+)\s*}}`)
+
+// keyNamer creates the names of the stored secrets from the key names in the input, by expanding the key template
+// and adding the prefix.  It is nil when neither -prefix nor -key-template are used, so the input keys are stored
+// as-is.
+type keyNamer struct {
+	prefix   string
+	template string
+	vars     map[string]string
+}
+
+// namer is the keyNamer created from the -prefix, -key-template, and -key-var options
+var namer *keyNamer
+
+// newKeyNamer creates a keyNamer using the prefix, and the template with the provided variables.  An empty template
+// uses the key name as-is.  The template must use the {{key}} variable, and every other variable must be provided.
+func newKeyNamer(prefix, tmpl string, vars map[string]string) (*keyNamer, error) {
+	if len(tmpl) < 1 {
+		tmpl = "{{" + keyVar + "}}"
+	}
+
+	var hasKey bool
+	for _, m := range templateVar.FindAllStringSubmatch(tmpl, -1) {
+		if m[1] == keyVar {
+			hasKey = true
+			continue
+		}
+
+		if _, ok := vars[m[1]]; !ok {
+			return nil, fmt.Errorf("missing value for key template variable %s, use -key-var %s=value", m[1], m[1])
+		}
+	}
+
+	if !hasKey {
+		return nil, fmt.Errorf("key template %s does not use the {{%s}} variable", tmpl, keyVar)
+	}
+
+	return &keyNamer{prefix: prefix, template: tmpl, vars: vars}, nil
+}
+
+// name returns the name of the secret for the input key, which is the prefix followed by the expanded template.
+// Repeated '/' characters, from joining the prefix, variables, and key, are replaced with a single '/'.
+func (n *keyNamer) name(key string) string {
+	v := templateVar.ReplaceAllStringFunc(n.template, func(s string) string {
+		m := templateVar.FindStringSubmatch(s)
+		if m[1] == keyVar {
+			return key
+		}
+		return n.vars[m[1]]
+	})
+
+	v = n.prefix + v
+	for strings.Contains(v, "//") {
+		v = strings.Replace(v, "//", "/", -1)
+	}
+	return v
+}
+
+// applyKeyNames replaces the key of each secret with the name created by the namer, if any
+func applyKeyNames(secrets []*secret) {
+	if namer == nil {
+		return
+	}
+
+	for _, s := range secrets {
+		s.key = namer.name(s.key)
+	}
+}
+
+// normalizeKey returns the key name using the naming rules of the backend.  SSM requires a leading '/' for names
+// using a path hierarchy, and a leading '/' is discouraged for Secrets Manager names (see the README).  Other
+// backends use the key name as-is.
+func normalizeKey(be, key string) string {
+	switch be {
+	case ssmSvc:
+		if strings.Contains(key, "/") && !strings.HasPrefix(key, "/") {
+			return "/" + key
+		}
+	case secretsSvc:
+		return strings.TrimLeft(key, "/")
+	}
+	return key
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestNewKeyNamer(t *testing.T) {
+	vars := map[string]string{"env": "prod", "service": "app"}
+
+	t.Run("good", func(t *testing.T) {
+		for _, v := range []string{"", "{{key}}", "/{{env}}/{{service}}/{{key}}", "{{ env }}-{{ key }}"} {
+			if _, err := newKeyNamer("", v, vars); err != nil {
+				t.Errorf("unexpected error for %s: %v", v, err)
+			}
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if _, err := newKeyNamer("", "/{{env}}/{{service}}", vars); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("missing variable", func(t *testing.T) {
+		if _, err := newKeyNamer("", "/{{region}}/{{key}}", vars); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestKeyNamer_Name(t *testing.T) {
+	vars := map[string]string{"env": "prod", "service": "app"}
+
+	tests := []struct {
+		prefix, template, key, want string
+	}{
+		{"/prod/app/", "", "db_password", "/prod/app/db_password"},
+		{"staging/app/", "", "db_password", "staging/app/db_password"},
+		{"", "/{{env}}/{{service}}/{{key}}", "db_password", "/prod/app/db_password"},
+		{"", "{{ env }}.{{ key }}", "db_password", "prod.db_password"},
+		{"/org/", "/{{env}}/{{key}}", "/db/password", "/org/prod/db/password"},
+		{"", "{{key}}/{{key}}", "x", "x/x"},
+	}
+
+	for _, tc := range tests {
+		n, err := newKeyNamer(tc.prefix, tc.template, vars)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if v := n.name(tc.key); v != tc.want {
+			t.Errorf("unexpected name for %s%s: %s", tc.prefix, tc.template, v)
+		}
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		be, key, want string
+	}{
+		{ssmSvc, "prod/app/db_password", "/prod/app/db_password"},
+		{ssmSvc, "/prod/app/db_password", "/prod/app/db_password"},
+		{ssmSvc, "db_password", "db_password"},
+		{secretsSvc, "/prod/app/db_password", "prod/app/db_password"},
+		{secretsSvc, "prod/app/db_password", "prod/app/db_password"},
+		{s3Svc, "/prod/app/db_password", "/prod/app/db_password"},
+		{dynamoSvc, "prod/app/db_password", "prod/app/db_password"},
+	}
+
+	for _, tc := range tests {
+		if v := normalizeKey(tc.be, tc.key); v != tc.want {
+			t.Errorf("unexpected %s key for %s: %s", tc.be, tc.key, v)
+		}
+	}
+}
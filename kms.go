@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// kmsKeyResolver resolves KMS key IDs and aliases to key ARNs using DescribeKey, caching the ARN so that
+// each key is only looked up once, even if it is used by multiple key name prefixes
+type kmsKeyResolver struct {
+	c    kmsiface.KMSAPI
+	arns map[string]string
+	mu   sync.Mutex
+}
+
+func newKmsKeyResolver(c kmsiface.KMSAPI) *kmsKeyResolver {
+	return &kmsKeyResolver{c: c, arns: make(map[string]string)}
+}
+
+// resolve returns the ARN of the KMS key ARN, ID, or alias
+func (r *kmsKeyResolver) resolve(id string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.arns[id]; ok {
+		return v, nil
+	}
+
+	o, err := r.c.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(id)})
+	if err != nil {
+		return "", fmt.Errorf("failed to lookup KMS key %s, error: %v", id, err)
+	}
+
+	keyArn, err := arn.Parse(*o.KeyMetadata.Arn)
+	if err != nil {
+		return "", fmt.Errorf("bad key ARN: %v", err)
+	}
+
+	r.arns[id] = keyArn.String()
+	return r.arns[id], nil
+}
+
+// regionalKey returns the KMS key ARN, ID, or alias to use in the region.  Multi-region keys have the same key ID in
+// each region, so the ARN of a multi-region key from another region is changed to the ARN of the replica key in the
+// region.  Key IDs and aliases are returned unchanged, since they are looked up in the region of the KMS client.
+func regionalKey(id, region string) string {
+	a, err := arn.Parse(id)
+	if err != nil || len(region) < 1 || a.Region == region {
+		return id
+	}
+
+	if strings.HasPrefix(a.Resource, "key/mrk-") {
+		a.Region = region
+		return a.String()
+	}
+	return id
+}
+
+// kmsKeyFor returns the KMS key for the secret key name from the map of key name prefixes to KMS keys, using
+// the longest prefix which matches the key name.  If no prefix matches, def is returned.
+func kmsKeyFor(key string, m map[string]string, def string) string {
+	match := ""
+	k := def
+
+	for p, v := range m {
+		if strings.HasPrefix(key, p) && len(p) > len(match) {
+			match = p
+			k = v
+		}
+	}
+
+	return k
+}
+
+// missingKmsKeys returns a description of each secret stored in a backend requiring a KMS key which has no key, because
+// the secret record does not set one, no -kms-key-map prefix matches its name, and -k is not set.
+func missingKmsKeys(ts []target, secrets []*secret) []string {
+	var report []string
+
+	for _, s := range secrets {
+		if len(s.kmsKey) > 0 {
+			continue
+		}
+
+		for _, t := range ts {
+			k := t.key(s.key)
+			if t.SecretBackender == nil || !t.KmsRequired() || !t.owns(k) {
+				continue
+			}
+
+			if len(kmsKeyFor(k, kmsKeyMapArg, kmsKeyArg)) < 1 {
+				msg := fmt.Sprintf("no KMS key for %s in %s: no -kms-key-map prefix matches, and -k is not set", k, t)
+				report = append(report, msg)
+			}
+		}
+	}
+
+	return report
+}
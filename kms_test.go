@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKmsKeyResolver(t *testing.T) {
+	m := new(mockKmsClient)
+	r := newKmsKeyResolver(m)
+
+	t.Run("good", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			a, err := r.resolve("alias/my-key")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if a != "arn:aws:kms:us-east-1:012345678901:key/my-key" {
+				t.Errorf("unexpected ARN: %s", a)
+			}
+		}
+
+		if m.describes != 1 {
+			t.Errorf("key was not cached, %d DescribeKey calls", m.describes)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := r.resolve("missing"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
+func TestKmsKeyFor(t *testing.T) {
+	m := map[string]string{"/team-a/": "a", "/team-a/shared/": "shared", "/team-b": "b"}
+
+	tests := map[string]string{
+		"/team-a/x":          "a",
+		"/team-a/shared/x":   "shared",
+		"/team-b/x":          "b",
+		"/team-c/x":          "default",
+		"team-a/no-leading/": "default",
+	}
+
+	for k, want := range tests {
+		if v := kmsKeyFor(k, m, "default"); v != want {
+			t.Errorf("unexpected key for %s: %s", k, v)
+		}
+	}
+
+	if v := kmsKeyFor("/team-a/x", nil, ""); v != "" {
+		t.Errorf("unexpected key: %s", v)
+	}
+}
+
+func TestRegionalKey(t *testing.T) {
+	mrk := "arn:aws:kms:us-east-1:012345678901:key/mrk-1234abcd"
+	single := "arn:aws:kms:us-east-1:012345678901:key/1234abcd"
+
+	tests := []struct {
+		id, region, want string
+	}{
+		{mrk, "us-west-2", "arn:aws:kms:us-west-2:012345678901:key/mrk-1234abcd"},
+		{mrk, "us-east-1", mrk},
+		{mrk, "", mrk},
+		{single, "us-west-2", single},
+		{"alias/my-key", "us-west-2", "alias/my-key"},
+		{"mrk-1234abcd", "us-west-2", "mrk-1234abcd"},
+	}
+
+	for _, v := range tests {
+		if k := regionalKey(v.id, v.region); k != v.want {
+			t.Errorf("unexpected key for %s in %s: %s", v.id, v.region, k)
+		}
+	}
+}
+
+func TestMissingKmsKeys(t *testing.T) {
+	required := newMockBackend()
+	required.kmsRequired = true
+
+	ts := []target{{name: dynamoSvc, SecretBackender: required}, {name: ssmSvc, SecretBackender: newMockBackend()}}
+	secrets := []*secret{{key: "/team-a/x"}, {key: "/team-b/x"}, {key: "/team-b/y", kmsKey: "record-key"}}
+
+	kmsKeyMapArg = mapValue{"/team-a/": "alias/team-a"}
+	defer func() {
+		kmsKeyArg = ""
+		kmsKeyMapArg = make(mapValue)
+	}()
+
+	report := missingKmsKeys(ts, secrets)
+	if len(report) != 1 || !strings.Contains(report[0], "/team-b/x in dynamodb backend") {
+		t.Errorf("unexpected report: %v", report)
+	}
+
+	kmsKeyArg = "alias/default"
+	if report := missingKmsKeys(ts, secrets); len(report) > 0 {
+		t.Errorf("unexpected report: %v", report)
+	}
+}
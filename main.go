@@ -4,23 +4,25 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/mmmorris1975/simple-logger/logger"
 	"io"
+	"io/ioutil"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -32,11 +34,47 @@ var (
 
 	// program args
 	backendArg     string
+	regionsArg     string
+	roleArnArg     string
+	externalIDArg  string
+	roleNameArg    string
+	roleDurArg     time.Duration
+	roleMapArg     = make(mapValue)
+	endpointArgs   = make(map[string]*string)
+	configArg      string
+	profileArg     string
+	storageArg     string
+	prefixArg      string
+	templateArg    string
+	keyVarsArg     = make(mapValue)
 	dynamoTableArg string
 	bucketArg      string
+	fileArg        string
+	vaultMountArg  string
 	kmsKeyArg      string
 	ssmAdvanced    bool
+	createArg      bool
+	descriptionArg string
 	oneShotArg     bool
+	getArg         bool
+	planArg        bool
+	compareArg     bool
+	pruneArg       string
+	formatArg      string
+	schemaArg      string
+	concurrencyArg int
+	attemptsArg    int
+	rateArg        int
+	tagsArg        = make(mapValue)
+	kmsKeyMapArg   = make(mapValue)
+	contextArg     = make(mapValue)
+	autoContextArg bool
+	envelopeArg    bool
+	credstashArg   bool
+	sortKeyArg     string
+	flattenArg     bool
+	flattenSepArg  string
+	flattenArrArg  string
 	verboseArg     bool
 	versionArg     bool
 
@@ -47,30 +85,140 @@ var (
 	ssmSvc     = ssm.ServiceName
 	secretsSvc = secretsmanager.ServiceName
 	s3Svc      = s3.ServiceName
-	keyArn     arn.ARN
+	fileSvc    = "file"
+	vaultSvc   = "vault"
 
-	backends = sort.StringSlice{dynamoSvc, ssmSvc, secretsSvc, s3Svc}
+	backends = sort.StringSlice{dynamoSvc, ssmSvc, secretsSvc, s3Svc, fileSvc, vaultSvc}
+
+	planCreate    = "create"
+	planUpdate    = "update"
+	planUnchanged = "unchanged"
+	planDelete    = "delete"
+
+	// ErrSecretNotFound is returned by a backend Fetch() when the requested key does not exist
+	ErrSecretNotFound = errors.New("secret not found")
 )
 
 func init() {
 	backends.Sort()
 
 	flag.StringVar(&backendArg, "s", os.Getenv("SECRETS_BACKEND"),
-		fmt.Sprintf("Secrets storage backend: %s", strings.Join(backends, ", ")))
+		fmt.Sprintf("Secrets storage backend, or a comma-separated list of backends to store each secret in all of them: %s",
+			strings.Join(backends, ", ")))
+	flag.StringVar(&configArg, "config", os.Getenv("SECRETS_CONFIG"),
+		fmt.Sprintf("Path of the config file holding the -profile settings (default %s)", defaultConfigFile()))
+	flag.StringVar(&profileArg, "profile", os.Getenv("SECRETS_PROFILE"),
+		"Name of the config file profile to use for the options not set on the command line or using environment variables")
+	flag.StringVar(&regionsArg, "regions", os.Getenv("SECRETS_REGIONS"),
+		fmt.Sprintf("Comma-separated list of AWS regions to store each secret in, using a separate backend for each region (not used for %s and %s backends)",
+			fileSvc, vaultSvc))
+	flag.StringVar(&roleArnArg, "role-arn", os.Getenv("SECRETS_ROLE_ARN"),
+		"ARN of an IAM role to assume, used for all AWS API calls made by the program")
+	flag.StringVar(&externalIDArg, "external-id", os.Getenv("SECRETS_EXTERNAL_ID"),
+		"External ID to use when assuming the -role-arn and -role-map roles")
+	flag.StringVar(&roleNameArg, "role-session-name", os.Getenv("SECRETS_ROLE_SESSION_NAME"),
+		fmt.Sprintf("Session name to use when assuming the -role-arn and -role-map roles (default %s)", defaultRoleSessionName))
+	flag.DurationVar(&roleDurArg, "role-duration", checkDurationEnv("SECRETS_ROLE_DURATION", 15*time.Minute),
+		"Duration of the assumed role credentials, which are refreshed when they expire")
+	flag.Var(roleMapArg, "role-map",
+		"ARN of an IAM role to assume for key names starting with a prefix as prefix=role, may be repeated")
+	for _, e := range []struct{ svc, flag, env, note string }{
+		{dynamodb.EndpointsID, "dynamodb-endpoint", "DYNAMODB_ENDPOINT", ""},
+		{kms.EndpointsID, "kms-endpoint", "KMS_ENDPOINT", ""},
+		{s3.EndpointsID, "s3-endpoint", "S3_ENDPOINT", ", using path-style addressing"},
+		{secretsmanager.EndpointsID, "secretsmanager-endpoint", "SECRETS_MANAGER_ENDPOINT", ""},
+		{ssm.EndpointsID, "ssm-endpoint", "SSM_ENDPOINT", ""},
+		{sts.EndpointsID, "sts-endpoint", "STS_ENDPOINT", ""},
+	} {
+		endpointArgs[e.svc] = flag.String(e.flag, os.Getenv(e.env),
+			fmt.Sprintf("Custom endpoint URL for %s API requests, such as a LocalStack or VPC endpoint%s", e.svc, e.note))
+	}
+	flag.StringVar(&prefixArg, "prefix", os.Getenv("SECRETS_PREFIX"),
+		"Prefix added to the input key names to create the names of the stored secrets, such as /prod/app/")
+	flag.StringVar(&templateArg, "key-template", os.Getenv("KEY_TEMPLATE"),
+		"Template used to create the names of the stored secrets from the input key names, such as /{{env}}/{{service}}/{{key}}")
+	flag.Var(keyVarsArg, "key-var", "Value of a -key-template variable as name=value, may be repeated")
 	flag.StringVar(&dynamoTableArg, "t", os.Getenv("DYNAMODB_TABLE"),
 		fmt.Sprintf("DynamoDB table name, required only for %s backend, ignored by all others", dynamoSvc))
 	flag.StringVar(&bucketArg, "b", os.Getenv("S3_BUCKET"),
 		fmt.Sprintf("S3 bucket name, required only for %s backend, ignored by all others", s3Svc))
+	flag.StringVar(&storageArg, "storage-class", os.Getenv("S3_STORAGE_CLASS"),
+		fmt.Sprintf("S3 storage class, optional for %s backend (default %s), ignored by all others", s3Svc, s3.StorageClassStandard))
+	flag.StringVar(&fileArg, "f", os.Getenv("FILE_STORE"),
+		fmt.Sprintf("Local store file path, required only for %s backend, ignored by all others", fileSvc))
+	flag.StringVar(&vaultMountArg, "m", os.Getenv("VAULT_MOUNT"),
+		fmt.Sprintf("Vault KV v2 secrets engine mount path, optional for %s backend (default secret), ignored by all others", vaultSvc))
 	flag.StringVar(&kmsKeyArg, "k", os.Getenv("KMS_KEY"),
-		fmt.Sprintf("KMS key ARN, ID, or alias (required for %s and %s backends, optional for %s backend, not used for %s backend)",
+		fmt.Sprintf("KMS key ARN, ID, or alias (required for %s and %s backends, optional for %s backend and %s backend with -create, not used for %s and %s backends)",
+			dynamoSvc, s3Svc, ssmSvc, secretsSvc, fileSvc, vaultSvc))
+	flag.Var(kmsKeyMapArg, "kms-key-map",
+		fmt.Sprintf("KMS key for key names starting with a prefix as prefix=key, may be repeated (%s, %s, %s and %s backends only)",
 			dynamoSvc, s3Svc, ssmSvc, secretsSvc))
+	flag.Var(contextArg, "encryption-context",
+		fmt.Sprintf("KMS encryption context as key=value, may be repeated (%s backend only)", dynamoSvc))
+	flag.BoolVar(&autoContextArg, "auto-context", checkBoolEnv("DYNAMODB_AUTO_CONTEXT"),
+		fmt.Sprintf("Add the table name and item key to the KMS encryption context, optional for %s backend, ignored by all others", dynamoSvc))
+	flag.BoolVar(&envelopeArg, "envelope", checkBoolEnv("DYNAMODB_ENVELOPE"),
+		fmt.Sprintf("Use envelope encryption to store values larger than 4096 bytes, optional for %s backend, ignored by all others", dynamoSvc))
+	flag.StringVar(&sortKeyArg, "sort-key-value", os.Getenv("DYNAMODB_SORT_KEY_VALUE"),
+		fmt.Sprintf("Value to write into the table sort key: %s or %s, optional for %s backend (default %s), ignored by all others",
+			sortKeyVersion, sortKeyTimestamp, dynamoSvc, sortKeyVersion))
+	flag.BoolVar(&credstashArg, "credstash", checkBoolEnv("DYNAMODB_CREDSTASH"),
+		fmt.Sprintf("Read and write secrets using the credstash table format, optional for %s backend, ignored by all others", dynamoSvc))
 	flag.BoolVar(&ssmAdvanced, "a", checkBoolEnv("SSM_ADVANCED"),
 		fmt.Sprintf("Create SSM Parameter Store Advanced Parameters, optional for %s backend, ignored by all others", ssmSvc))
+	flag.BoolVar(&createArg, "create", checkBoolEnv("SECRETS_CREATE"),
+		fmt.Sprintf("Create secrets which do not exist, optional for %s backend, ignored by all others", secretsSvc))
+	flag.StringVar(&descriptionArg, "description", os.Getenv("SECRETS_DESCRIPTION"),
+		fmt.Sprintf("Description for created secrets, optional for %s backend, ignored by all others", secretsSvc))
+	flag.Var(tagsArg, "tag",
+		fmt.Sprintf("Tag to apply to stored secrets as key=value, may be repeated (%s, %s and %s backends only)", ssmSvc, secretsSvc, s3Svc))
 	flag.BoolVar(&oneShotArg, "o", checkBoolEnv("ONE_SHOT"), "run in one-shot mode, providing the key and value to store on the command line")
+	flag.BoolVar(&getArg, "g", false, "run in get mode, printing the value of the key provided on the command line")
+	flag.BoolVar(&planArg, "plan", checkBoolEnv("PLAN"), "show the changes which would be made by the json input, without storing any secrets")
+	flag.BoolVar(&compareArg, "c", checkBoolEnv("SKIP_UNCHANGED"), "compare with the stored value before writing, and skip secrets which are unchanged")
+	flag.StringVar(&pruneArg, "prune", os.Getenv("PRUNE_PREFIX"), "delete keys under this prefix which are not found in the json input")
+	flag.StringVar(&formatArg, "format", os.Getenv("INPUT_FORMAT"),
+		fmt.Sprintf("Input format: %s (default %s)", strings.Join(formats, ", "), formatJSON))
+	flag.StringVar(&schemaArg, "schema", os.Getenv("INPUT_SCHEMA"),
+		fmt.Sprintf("Input schema: %s for a map of keys and values, %s for a map of keys and secret records with metadata (default %s)",
+			schemaSimple, schemaRecord, schemaSimple))
+	flag.BoolVar(&flattenArg, "flatten", checkBoolEnv("FLATTEN"),
+		"Flatten nested input values into separate keys, using the path of the nested keys as the key name")
+	flag.StringVar(&flattenSepArg, "flatten-sep", os.Getenv("FLATTEN_SEPARATOR"),
+		"Separator used to join nested key names when using -flatten (default /)")
+	flag.StringVar(&flattenArrArg, "flatten-arrays", os.Getenv("FLATTEN_ARRAYS"),
+		fmt.Sprintf("Array handling when using -flatten, %s stores the array as a json value, %s stores each element using its index as the key name (default %s)",
+			flattenArraysJSON, flattenArraysIndex, flattenArraysJSON))
+	flag.IntVar(&concurrencyArg, "concurrency", checkIntEnv("CONCURRENCY", 1), "Number of secrets to store concurrently in json mode")
+	flag.IntVar(&attemptsArg, "max-attempts", checkIntEnv("MAX_ATTEMPTS", 4),
+		"Maximum number of attempts for each AWS API request, retrying throttled and transient errors with exponential backoff")
+	flag.IntVar(&rateArg, "rate", checkIntEnv("RATE_LIMIT", 0),
+		"Maximum number of AWS API requests per second made by the backend, including retries (default 0, unlimited)")
 	flag.BoolVar(&verboseArg, "v", checkBoolEnv("VERBOSE"), "Print verbose output")
 	flag.BoolVar(&versionArg, "V", false, "Print program version")
 }
 
+// kmsKeySetter is implemented by backends which encrypt values using the KMS key provided to the program.
+// The key is resolved to an ARN once, and set on the backend so that Store() calls have no shared state.
+// The key map holds the ARN of the KMS key to use for each key name prefix, see kmsKeyFor().
+type kmsKeySetter interface {
+	setKmsKey(string)
+	setKmsKeyMap(map[string]string)
+}
+
+// recordStorer is implemented by backends which are able to store the metadata of a secret decoded from the
+// input, in addition to the value.  Metadata fields which the backend does not support are ignored with a warning.
+type recordStorer interface {
+	storeRecord(*secret) error
+}
+
+// valueFetcher is implemented by backends which do not return values from Fetch() exactly as they were stored,
+// so that the value to store can be compared with the stored value
+type valueFetcher interface {
+	fetchValue(string) []byte
+}
+
 // SecretBackender is the interface type for conforming secrets backends
 type SecretBackender interface {
 	// KmsRequired returns true if the backend requires a KMS key argument for operation. Currently, only
@@ -79,6 +227,16 @@ type SecretBackender interface {
 
 	// Store will set the supplied value in the backend as the provided key
 	Store(string, interface{}) error
+
+	// Fetch will retrieve the decrypted value of the provided key from the backend.  If the key does
+	// not exist in the backend, ErrSecretNotFound is returned
+	Fetch(string) ([]byte, error)
+
+	// List will return the keys stored in the backend which start with the provided prefix
+	List(string) ([]string, error)
+
+	// Delete will remove the provided key from the backend
+	Delete(string) error
 }
 
 func main() {
@@ -88,19 +246,104 @@ func main() {
 		log.SetLevel(logger.DEBUG)
 	}
 
+	if len(profileArg) > 0 {
+		if len(configArg) < 1 {
+			configArg = defaultConfigFile()
+		}
+
+		p, err := loadProfile(configArg, profileArg)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := applyProfile(flag.CommandLine, p); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if versionArg {
 		log.Printf("VERSION: %s", Version)
 	}
 
+	// tags on the command line take precedence over the environment variable
+	envTags, err := parsePairs(os.Getenv("SECRETS_TAGS"))
+	if err != nil {
+		log.Fatalf("invalid SECRETS_TAGS: %v", err)
+	}
+	tagsArg = mergeTags(envTags, tagsArg)
+
+	envKeys, err := parsePairs(os.Getenv("KMS_KEY_MAP"))
+	if err != nil {
+		log.Fatalf("invalid KMS_KEY_MAP: %v", err)
+	}
+	kmsKeyMapArg = mergeTags(envKeys, kmsKeyMapArg)
+
+	envContext, err := parsePairs(os.Getenv("DYNAMODB_ENCRYPTION_CONTEXT"))
+	if err != nil {
+		log.Fatalf("invalid DYNAMODB_ENCRYPTION_CONTEXT: %v", err)
+	}
+	contextArg = mergeTags(envContext, contextArg)
+
+	envRoles, err := parsePairs(os.Getenv("SECRETS_ROLE_MAP"))
+	if err != nil {
+		log.Fatalf("invalid SECRETS_ROLE_MAP: %v", err)
+	}
+	roleMapArg = mergeTags(envRoles, roleMapArg)
+
+	if err := validateFlatten(); err != nil {
+		log.Fatal(err)
+	}
+
+	envVars, err := parsePairs(os.Getenv("KEY_TEMPLATE_VARS"))
+	if err != nil {
+		log.Fatalf("invalid KEY_TEMPLATE_VARS: %v", err)
+	}
+	keyVarsArg = mergeTags(envVars, keyVarsArg)
+
+	if len(prefixArg) > 0 || len(templateArg) > 0 {
+		if namer, err = newKeyNamer(prefixArg, templateArg, keyVarsArg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	e := make(map[string]string, len(endpointArgs))
+	for svc, v := range endpointArgs {
+		e[svc] = *v
+	}
+
+	if err := setEndpoints(e); err != nil {
+		log.Fatal(err)
+	}
+
+	// must happen before the backend is created, since the AWS clients copy the session config
+	ses = withRetries(ses, attemptsArg)
+
 	if err := validateBackend(); err != nil {
 		log.Fatal(err)
 	}
 
+	errCnt := 0
+	if getArg {
+		// reading values only needs kms:Decrypt, and the key is discovered from the stored ciphertext.  With
+		// multiple backends, the value is read from the first one.
+		log.Debug("using get mode")
+		if err := getHandler(flag.Arg(0), os.Stdout); err != nil {
+			log.Fatalf("error retrieving secret: %v", err)
+		}
+
+		os.Exit(errCnt)
+	}
+
+	if planArg {
+		// nothing is stored in plan mode, so there's no need to lookup the KMS key
+		log.Debug("using plan mode")
+		os.Exit(planHandler(jsonInput(), os.Stdout))
+	}
+
 	if err := validateKey(); err != nil {
 		log.Fatal(err)
 	}
 
-	errCnt := 0
 	if oneShotArg {
 		log.Debug("using one-shot mode")
 		var v interface{}
@@ -116,81 +359,398 @@ func main() {
 		}
 	} else {
 		log.Debug("using json mode")
-		var in interface{}
+		errCnt = jsonHandler(jsonInput())
+	}
 
-		if len(flag.Arg(0)) > 0 {
-			in = flag.Arg(0)
-		} else {
-			in = os.Stdin
+	os.Exit(errCnt)
+}
+
+// the json input is the 1st command argument, or stdin if no argument was provided
+func jsonInput() interface{} {
+	if len(flag.Arg(0)) > 0 {
+		return flag.Arg(0)
+	}
+	return os.Stdin
+}
+
+// oneShotHandler stores the value in each backend, returning an error if storing failed in any of them.  With -c,
+// backends which already store the value are skipped.
+func oneShotHandler(k string, v interface{}) error {
+	ts := activeTargets()
+	if namer != nil {
+		k = namer.name(k)
+	}
+
+	// a reader can only be consumed once, so read the whole value for multiple backends, or to compare it.
+	// Other values are passed as-is, since some backends only store strings.
+	if r, ok := v.(io.Reader); ok && (len(ts) > 1 || compareArg) {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
 		}
+		v = b
+	}
 
-		errCnt = jsonHandler(in)
+	// check every backend has a KMS key before storing the value in any of them
+	if report := missingKmsKeys(ts, []*secret{{key: k}}); len(report) > 0 {
+		return errors.New(report[0])
 	}
 
-	os.Exit(errCnt)
+	var failed []string
+	for _, t := range ts {
+		key := t.key(k)
+		if !t.owns(key) {
+			continue
+		}
+
+		if compareArg {
+			action, _, err := compareSecret(t.SecretBackender, key, fmt.Sprintf("%s", v))
+			if err != nil {
+				if len(ts) < 2 {
+					return err
+				}
+
+				log.Errorf("error fetching secret%s: %v", t.where(), err)
+				failed = append(failed, t.String())
+				continue
+			}
+
+			if action == planUnchanged {
+				log.Infof("unchanged secret %s%s", key, t.where())
+				continue
+			}
+		}
+
+		if err := t.Store(key, v); err != nil {
+			if len(ts) < 2 {
+				return err
+			}
+
+			log.Errorf("error storing secret%s: %v", t.where(), err)
+			failed = append(failed, t.String())
+			continue
+		}
+
+		log.Infof("updated secret %s%s", key, t.where())
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to store %s in: %s", k, strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-func oneShotHandler(k string, v interface{}) error {
-	if err := sb.Store(k, v); err != nil {
+// getHandler writes the value of the key from the first backend to w, using the role mapped to the key name
+func getHandler(k string, w io.Writer) error {
+	if len(k) < 1 {
+		return fmt.Errorf("missing required key name")
+	}
+
+	if namer != nil {
+		k = namer.name(k)
+	}
+
+	// read the key using the name created for the first backend which stores it
+	b, key := sb, k
+	for _, t := range activeTargets() {
+		if n := t.key(k); t.owns(n) {
+			b, key = t.SecretBackender, n
+			break
+		}
+	}
+
+	v, err := b.Fetch(key)
+	if err != nil {
 		return err
 	}
 
-	log.Infof("updated secret %s", k)
-	return nil
+	_, err = w.Write(v)
+	return err
 }
 
 func jsonHandler(in interface{}) int {
 	var errs int
 
-	r := getReader(in)
-	if r == nil {
-		log.Error("received a nil reader to handle json input, something has gone very wrong")
+	secrets, err := decodeInput(in)
+	if err != nil {
+		log.Error(err)
 		errs++
 		return errs
 	}
+	applyKeyNames(secrets)
 
-	j := json.NewDecoder(r)
-	for {
-		m := make(map[string]interface{})
-		if err := j.Decode(&m); err != nil {
-			if err == io.EOF {
-				break
-			}
+	ts := activeTargets()
 
-			// bad json, should probably not continue
-			log.Errorf("error decoding json: %v", err)
-			errs++
-			break
+	// reject the whole input before storing anything, rather than failing part way through the batch
+	if report := validateSecrets(ts, secrets); len(report) > 0 {
+		for _, v := range report {
+			log.Error(v)
 		}
 
-		for k, v := range m {
-			var err error
+		log.Errorf("found %d invalid key names, no secrets were stored", len(report))
+		return len(report)
+	}
 
-			switch t := v.(type) {
-			case string:
-				// plain string, just store it
-				err = sb.Store(k, t)
-			default:
-				// not a string, see if it was some nested json which we can re-encode and store
-				jv, err := json.Marshal(t)
-				if err != nil {
-					break
+	if report := missingKmsKeys(ts, secrets); len(report) > 0 {
+		for _, v := range report {
+			log.Error(v)
+		}
+
+		log.Errorf("found %d secrets without a KMS key, no secrets were stored", len(report))
+		return len(report)
+	}
+
+	n := concurrencyArg
+	if n < 1 {
+		n = 1
+	}
+
+	ch := make(chan *secret)
+	cnt := make(chan []int)
+
+	// each worker counts the errors for each backend
+	for i := 0; i < n; i++ {
+		go func() {
+			e := make([]int, len(ts))
+			for s := range ch {
+				for j, t := range ts {
+					if s := t.secret(s); t.owns(s.key) {
+						e[j] += storeSecret(t, s)
+					}
 				}
-				err = sb.Store(k, string(jv))
 			}
+			cnt <- e
+		}()
+	}
 
-			if err != nil {
-				log.Errorf("error storing secret: %v", err)
-				errs++
-			} else {
-				log.Infof("updated secret %s", k)
+	for _, s := range secrets {
+		ch <- s
+	}
+	close(ch)
+
+	failed := make([]int, len(ts))
+	for i := 0; i < n; i++ {
+		for j, e := range <-cnt {
+			failed[j] += e
+			errs += e
+		}
+	}
+
+	if len(ts) > 1 {
+		for i, t := range ts {
+			var owned int
+			for _, s := range secrets {
+				if t.owns(t.key(s.key)) {
+					owned++
+				}
 			}
+			log.Infof("stored %d of %d secrets in %s", owned-failed[i], owned, t)
 		}
 	}
 
+	if len(pruneArg) > 0 {
+		errs += pruneSecrets(secrets, pruneArg, false, nil)
+	}
+
 	return errs
 }
 
+// storeSecret writes a single secret to the backend, returning the number of errors encountered.  This
+// is called concurrently by the jsonHandler workers, so must not modify any shared state.
+func storeSecret(t target, s *secret) int {
+	if compareArg {
+		action, _, err := compareSecret(t.SecretBackender, s.key, s.value)
+		if err != nil {
+			log.Errorf("error fetching secret%s: %v", t.where(), err)
+			return 1
+		}
+
+		if action == planUnchanged {
+			log.Infof("unchanged secret %s%s", s.key, t.where())
+			return 0
+		}
+	}
+
+	var err error
+	if r, ok := t.SecretBackender.(recordStorer); ok {
+		err = r.storeRecord(s)
+	} else {
+		warnUnsupported(t.name, s, s.fields()...)
+		err = t.Store(s.key, s.value)
+	}
+
+	if err != nil {
+		log.Errorf("error storing secret%s: %v", t.where(), err)
+		return 1
+	}
+
+	log.Infof("updated secret %s%s", s.key, t.where())
+	return 0
+}
+
+// warnUnsupported logs a warning for each of the provided metadata fields which are set for the secret, but
+// are not supported by the named backend
+func warnUnsupported(be string, s *secret, fields ...string) {
+	set := make(map[string]bool)
+	for _, f := range s.fields() {
+		set[f] = true
+	}
+
+	for _, f := range fields {
+		if set[f] {
+			log.Warnf("%s is not supported by the %s backend, ignoring it for %s", f, be, s.key)
+		}
+	}
+}
+
+// planHandler decodes the input the same way as jsonHandler, and writes a report of the changes a
+// jsonHandler run would make to w, without storing anything.  Secret values are never written to
+// the report, only their length.  With multiple backends, there is a section of the report for each.
+func planHandler(in interface{}, w io.Writer) int {
+	var errs int
+
+	secrets, err := decodeInput(in)
+	if err != nil {
+		log.Error(err)
+		errs++
+		return errs
+	}
+	applyKeyNames(secrets)
+
+	// report the names json mode would reject, then show the rest of the plan
+	ts := activeTargets()
+	for _, v := range append(validateSecrets(ts, secrets), missingKmsKeys(ts, secrets)...) {
+		log.Error(v)
+		errs++
+	}
+
+	for _, t := range ts {
+		if len(ts) > 1 {
+			fmt.Fprintf(w, "%s:\n", t)
+		}
+
+		errs += planTarget(t, secrets, w)
+	}
+
+	return errs
+}
+
+// planTarget writes the changes needed to make the backend match the input to w, including the keys
+// deleted by -prune, returning the number of errors encountered
+func planTarget(t target, secrets []*secret, w io.Writer) int {
+	var errs int
+
+	for _, s := range secrets {
+		s = t.secret(s)
+		if !t.owns(s.key) {
+			continue
+		}
+
+		action, cur, err := compareSecret(t.SecretBackender, s.key, s.value)
+		if err != nil {
+			log.Errorf("error fetching secret%s: %v", t.where(), err)
+			errs++
+			continue
+		}
+
+		switch action {
+		case planCreate:
+			fmt.Fprintf(w, "%-9s %s (%d bytes)\n", action, s.key, len(s.value))
+		case planUpdate:
+			fmt.Fprintf(w, "%-9s %s (%d bytes -> %d bytes)\n", action, s.key, len(cur), len(s.value))
+		default:
+			fmt.Fprintf(w, "%-9s %s\n", action, s.key)
+		}
+	}
+
+	if len(pruneArg) > 0 {
+		errs += pruneTarget(t, secrets, pruneArg, true, w)
+	}
+
+	return errs
+}
+
+// pruneSecrets deletes the keys found in each backend under prefix which are not in the list of secrets,
+// returning the number of keys which failed to delete.  If preview is true, the keys which would be
+// deleted are written to w, and nothing is deleted.
+func pruneSecrets(secrets []*secret, prefix string, preview bool, w io.Writer) int {
+	var errs int
+
+	for _, t := range activeTargets() {
+		errs += pruneTarget(t, secrets, prefix, preview, w)
+	}
+
+	return errs
+}
+
+// pruneTarget deletes the keys found in the backend under prefix which are not in the list of secrets
+func pruneTarget(t target, secrets []*secret, prefix string, preview bool, w io.Writer) int {
+	var errs int
+
+	// an empty input would delete everything under the prefix, which is almost certainly a mistake
+	if len(secrets) < 1 {
+		log.Errorf("refusing to prune %s using empty input", prefix)
+		errs++
+		return errs
+	}
+
+	keys, err := t.List(t.key(prefix))
+	if err != nil {
+		log.Errorf("error listing secrets%s: %v", t.where(), err)
+		errs++
+		return errs
+	}
+	sort.Strings(keys)
+
+	found := make(map[string]bool)
+	for _, s := range secrets {
+		found[t.key(s.key)] = true
+	}
+
+	// keys using a role mapped to a longer prefix are pruned using the target for that role
+	for _, k := range keys {
+		if found[k] || !t.owns(k) {
+			continue
+		}
+
+		if preview {
+			fmt.Fprintf(w, "%-9s %s\n", planDelete, k)
+			continue
+		}
+
+		if err := t.Delete(k); err != nil {
+			log.Errorf("error deleting secret%s: %v", t.where(), err)
+			errs++
+		} else {
+			log.Infof("deleted secret %s%s", k, t.where())
+		}
+	}
+
+	return errs
+}
+
+// compareSecret fetches the current value of key from the backend, and returns the action needed to
+// make the stored value match the provided value, along with the currently stored value (if any)
+func compareSecret(b SecretBackender, key, value string) (string, []byte, error) {
+	cur, err := b.Fetch(key)
+	if err != nil {
+		if err == ErrSecretNotFound {
+			return planCreate, nil, nil
+		}
+		return "", nil, err
+	}
+
+	want := []byte(value)
+	if f, ok := b.(valueFetcher); ok {
+		want = f.fetchValue(value)
+	}
+
+	if bytes.Equal(cur, want) {
+		return planUnchanged, cur, nil
+	}
+	return planUpdate, cur, nil
+}
+
 // truth-y values are 1, t, T, TRUE, true, True; everything else is false
 func checkBoolEnv(v string) bool {
 	log.Debugf("checkBoolEnv input: %s", v)
@@ -203,63 +763,314 @@ func checkBoolEnv(v string) bool {
 	return b
 }
 
-// verify that we're called with a supported secrets backend
+// the default is returned if the environment variable is not set, or is not an integer
+func checkIntEnv(v string, def int) int {
+	i, err := strconv.Atoi(os.Getenv(v))
+	if err != nil {
+		log.Debugf("Atoi error: %v", err)
+		return def
+	}
+	return i
+}
+
+// the default is returned if the environment variable is not set, or is not a duration like 1h or 30m
+func checkDurationEnv(v string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(v))
+	if err != nil {
+		log.Debugf("ParseDuration error: %v", err)
+		return def
+	}
+	return d
+}
+
+// verify that we're called with supported secrets backends, creating each of them in each region, and for each
+// key name prefix mapped to an IAM role.  The first backend is also set as sb, for the modes which only use a
+// single backend.
 func validateBackend() error {
-	backendLc := strings.ToLower(backendArg)
-	i := backends.Search(backendLc)
+	targets = nil
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+
+	for _, be := range strings.Split(backendArg, ",") {
+		backendLc := strings.ToLower(strings.TrimSpace(be))
+		i := backends.Search(backendLc)
+
+		if i >= len(backends) || backends[i] != backendLc {
+			return fmt.Errorf("backend %s is not valid, must be one of: %s", be, strings.Join(backends, ", "))
+		}
+
+		if seen[backendLc] {
+			return fmt.Errorf("backend %s was provided more than once", be)
+		}
+		seen[backendLc] = true
+		names = append(names, backends[i])
+	}
+
+	regions, err := parseRegions(regionsArg)
+	if err != nil {
+		return err
+	}
+
+	sessions, prefixes, err := roleSessions(ses, roleArnArg, roleMapArg,
+		roleOptions{externalID: externalIDArg, sessionName: roleNameArg, duration: roleDurArg})
+	if err != nil {
+		return err
+	}
+
+	for _, be := range names {
+		for _, p := range prefixes {
+			// the file and vault backends do not use AWS credentials or regions, so are only created once
+			if len(p) > 0 && (be == fileSvc || be == vaultSvc) {
+				log.Warnf("the %s backend does not use AWS credentials, ignoring the role for %s", be, p)
+				continue
+			}
+
+			for _, r := range regions {
+				if len(r) > 0 && (be == fileSvc || be == vaultSvc) {
+					log.Warnf("the %s backend does not use AWS regions, ignoring -regions", be)
+					r = ""
+				}
+
+				s := sessions[p]
+				if len(r) > 0 {
+					s = s.Copy(aws.NewConfig().WithRegion(r))
+				}
+
+				// each backend, region, and role has its own -rate limit
+				s = withRateLimit(s, float64(rateArg))
+
+				t := target{name: be, region: r, prefix: p, session: s}
+				if err := backendFactory(be, s); err != nil {
+					if len(r) > 0 || len(p) > 0 {
+						return fmt.Errorf("%s: %v", t, err)
+					}
+					return err
+				}
+
+				t.SecretBackender = sb
+				targets = append(targets, t)
+
+				if len(r) < 1 {
+					break
+				}
+			}
+		}
+	}
 
-	if i >= len(backends) || backends[i] != backendLc {
-		return fmt.Errorf("backend %s is not valid, must be one of: %s", backendArg, strings.Join(backends, ", "))
+	sb = targets[0].SecretBackender
+	return nil
+}
+
+// parseRegions returns the regions from the comma-separated list, or a single empty region, meaning the
+// default region of the session, if the list is empty
+func parseRegions(s string) ([]string, error) {
+	if len(strings.TrimSpace(s)) < 1 {
+		return []string{""}, nil
 	}
 
-	return backendFactory(backends[i])
+	seen := make(map[string]bool)
+	regions := make([]string, 0)
+
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) < 1 {
+			return nil, fmt.Errorf("invalid regions %s, empty region name", s)
+		}
+
+		if seen[r] {
+			return nil, fmt.Errorf("region %s was provided more than once", r)
+		}
+		seen[r] = true
+		regions = append(regions, r)
+	}
+
+	return regions, nil
 }
 
-// KMS key is required, or a KMS key was explicitly passed with the ssm backend
+// KMS key is required, or a KMS key (or key map) was explicitly passed with the ssm backend, or with the
+// secretsmanager backend when creating secrets, for any of the backends
 func validateKey() error {
-	if (sb != nil && sb.KmsRequired()) || (backendArg == ssm.ServiceName && len(kmsKeyArg) > 0) {
-		c := kms.New(ses)
-		i := kms.DescribeKeyInput{KeyId: aws.String(kmsKeyArg)}
-		o, err := c.DescribeKey(&i)
-		if err != nil {
-			return fmt.Errorf("failed to lookup KMS key %s, error: %v", kmsKeyArg, err)
+	provided := len(kmsKeyArg) > 0 || len(kmsKeyMapArg) > 0
+	resolve := false
+
+	for _, t := range activeTargets() {
+		optional := t.name == ssm.ServiceName || (t.name == secretsSvc && createArg)
+
+		if (t.SecretBackender != nil && t.KmsRequired()) || (optional && provided) {
+			resolve = true
 		}
+	}
 
-		keyArn, err = arn.Parse(*o.KeyMetadata.Arn)
-		if err != nil {
-			return fmt.Errorf("bad key ARN: %v", err)
+	if !resolve {
+		return nil
+	}
+
+	// KMS keys are regional, and aliases are per account, so the keys are looked up using a KMS client in
+	// each region, using the credentials for each role
+	type account struct{ region, prefix string }
+	done := make(map[account]bool)
+
+	for _, t := range activeTargets() {
+		if done[account{t.region, t.prefix}] {
+			continue
+		}
+		done[account{t.region, t.prefix}] = true
+
+		if err := resolveKeys(newKmsKeyResolver(kms.New(t.session, endpointConfig(kms.EndpointsID))), t); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func backendFactory(be string) error {
+// resolveKeys looks up the ARN of the -k KMS key, and each KMS key in the -kms-key-map, and sets them on the
+// backends using the same region and role as the provided target.  Multi-region key ARNs from another region
+// are resolved to the replica key in the region.
+func resolveKeys(r *kmsKeyResolver, want target) error {
+	region := want.region
+
+	if len(kmsKeyArg) < 1 && len(kmsKeyMapArg) < 1 {
+		return fmt.Errorf("a KMS key is required for the %s backend", backendArg)
+	}
+
+	var keyArn string
+	var keyMap map[string]string
+
+	if len(kmsKeyArg) > 0 {
+		var err error
+		if keyArn, err = r.resolve(regionalKey(kmsKeyArg, region)); err != nil {
+			return err
+		}
+	}
+
+	if len(kmsKeyMapArg) > 0 {
+		keyMap = make(map[string]string, len(kmsKeyMapArg))
+		for p, id := range kmsKeyMapArg {
+			v, err := r.resolve(regionalKey(id, region))
+			if err != nil {
+				return err
+			}
+			keyMap[p] = v
+		}
+	}
+
+	for _, t := range activeTargets() {
+		k, ok := t.SecretBackender.(kmsKeySetter)
+		if !ok || t.region != want.region || t.prefix != want.prefix {
+			continue
+		}
+
+		if len(keyArn) > 0 {
+			k.setKmsKey(keyArn)
+		}
+
+		if len(keyMap) > 0 {
+			k.setKmsKeyMap(keyMap)
+		}
+	}
+
+	return nil
+}
+
+// backendFactory creates the named backend, setting it as sb.  The AWS backends use the provided session.
+func backendFactory(be string, s *session.Session) error {
 	switch be {
 	case dynamoSvc:
 		if len(dynamoTableArg) < 1 {
 			return fmt.Errorf("missing required table name for %s backend", dynamoSvc)
 		}
 
-		var err error
-		sb, err = NewDynamoDbBackend().WithTable(dynamoTableArg)
+		if len(sortKeyArg) < 1 {
+			sortKeyArg = sortKeyVersion
+		}
 
+		if sortKeyArg != sortKeyVersion && sortKeyArg != sortKeyTimestamp {
+			return fmt.Errorf("invalid sort key value %s, must be %s or %s", sortKeyArg, sortKeyVersion, sortKeyTimestamp)
+		}
+
+		b, err := NewDynamoDbBackend().WithSession(s).WithTable(dynamoTableArg)
 		if err != nil {
 			return err
 		}
+
+		if credstashArg {
+			if b.pk != credstashName || b.sk != credstashVersion || b.skType != dynamodb.ScalarAttributeTypeS {
+				return fmt.Errorf("table %s does not use the credstash key schema", dynamoTableArg)
+			}
+
+			if sortKeyArg != sortKeyVersion {
+				return fmt.Errorf("credstash tables require the %s sort key value", sortKeyVersion)
+			}
+
+			if envelopeArg {
+				log.Warnf("credstash always uses envelope encryption, ignoring -envelope")
+			}
+		}
+
+		sb = b.WithEncryptionContext(contextArg).WithAutoContext(autoContextArg).WithEnvelope(envelopeArg).
+			WithSortKeyValue(sortKeyArg).WithCredstash(credstashArg)
 	case secretsSvc:
-		sb = NewSecretsManagerBackend()
+		sb = NewSecretsManagerBackend().WithSession(s).WithCreate(createArg).WithDescription(descriptionArg).WithTags(tagsArg)
 	case ssmSvc:
-		sb = NewParameterStoreBackend().WithAdvanced(ssmAdvanced)
+		sb = NewParameterStoreBackend().WithSession(s).WithAdvanced(ssmAdvanced).WithTags(tagsArg)
 	case s3Svc:
 		if len(bucketArg) < 1 {
 			return fmt.Errorf("missing required bucket name for %s backend", s3Svc)
 		}
 
-		sb = NewS3Backend().WithBucket(bucketArg)
+		b := NewS3Backend().WithSession(s).WithBucket(bucketArg).WithTags(tagsArg)
+		if len(storageArg) > 0 {
+			b.WithStorageClass(storageArg)
+		}
+		sb = b
+	case fileSvc:
+		if len(fileArg) < 1 {
+			return fmt.Errorf("missing required store file for %s backend", fileSvc)
+		}
+
+		var err error
+		b := NewFileBackend().WithFile(fileArg)
+
+		if v, ok := os.LookupEnv("FILE_KEY_FILE"); ok {
+			b, err = b.WithKeyFile(v)
+		} else if v, ok := os.LookupEnv("FILE_PASSPHRASE"); ok {
+			b, err = b.WithPassphrase(v)
+		} else {
+			err = fmt.Errorf("one of FILE_KEY_FILE or FILE_PASSPHRASE is required for %s backend", fileSvc)
+		}
+
+		if err != nil {
+			return err
+		}
+		sb = b
+	case vaultSvc:
+		b := NewVaultBackend()
+		if len(vaultMountArg) > 0 {
+			b = b.WithMount(vaultMountArg)
+		}
+
+		// an explicit token takes precedence over AppRole credentials
+		if len(b.token) < 1 {
+			r, rok := os.LookupEnv("VAULT_ROLE_ID")
+			s, sok := os.LookupEnv("VAULT_SECRET_ID")
+			if !rok || !sok {
+				return fmt.Errorf("one of VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID, is required for %s backend", vaultSvc)
+			}
+
+			var err error
+			if b, err = b.WithAppRole(r, s); err != nil {
+				return err
+			}
+		}
+		sb = b
 	default:
 		return fmt.Errorf("unsupported backend %s", be)
 	}
 
+	if len(tagsArg) > 0 && be != ssmSvc && be != secretsSvc && be != s3Svc {
+		log.Warnf("tags are not supported by the %s backend, ignoring", be)
+	}
+
 	log.Debugf("setting backend to %s", be)
 	return nil
 }
@@ -272,6 +1083,8 @@ func readBinary(value interface{}) (io.Reader, error) {
 	switch t := value.(type) {
 	case io.Reader:
 		return t, nil
+	case []byte:
+		return bytes.NewReader(t), nil
 	}
 
 	b := bytes.NewBuffer(make([]byte, 0, 4096))
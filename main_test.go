@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"fmt"
 	"math"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
 )
 
 func TestCheckBoolEnv(t *testing.T) {
@@ -95,7 +98,10 @@ func TestCheckBoolEnv(t *testing.T) {
 }
 
 func TestValidateBackend(t *testing.T) {
-	defer func() { backendArg = "" }()
+	defer func() {
+		backendArg = ""
+		targets = nil
+	}()
 
 	t.Run("valid", func(t *testing.T) {
 		backendArg = "SSM"
@@ -112,6 +118,75 @@ func TestValidateBackend(t *testing.T) {
 			return
 		}
 	})
+
+	t.Run("multiple", func(t *testing.T) {
+		backendArg = "ssm, secretsmanager"
+		if err := validateBackend(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(targets) != 2 || targets[0].name != "ssm" || targets[1].name != "secretsmanager" {
+			t.Errorf("unexpected backends: %v", targets)
+			return
+		}
+
+		if sb != targets[0].SecretBackender {
+			t.Error("first backend was not set as sb")
+		}
+	})
+
+	t.Run("regions", func(t *testing.T) {
+		backendArg = "ssm,secretsmanager"
+		regionsArg = "us-east-1, us-west-2"
+		defer func() { regionsArg = "" }()
+
+		if err := validateBackend(); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(targets) != 4 {
+			t.Errorf("unexpected backends: %v", targets)
+			return
+		}
+
+		for i, want := range []string{"ssm backend in us-east-1", "ssm backend in us-west-2",
+			"secretsmanager backend in us-east-1", "secretsmanager backend in us-west-2"} {
+			if s := targets[i].String(); s != want {
+				t.Errorf("unexpected backend %d: %s", i, s)
+			}
+
+			if r := *targets[i].session.Config.Region; r != targets[i].region {
+				t.Errorf("unexpected session region %s for %s", r, targets[i])
+			}
+		}
+	})
+
+	t.Run("multiple invalid", func(t *testing.T) {
+		for _, v := range []string{"ssm,ParameterStore", "ssm,ssm", "ssm,"} {
+			backendArg = v
+			if err := validateBackend(); err == nil {
+				t.Errorf("did not receive expected error for %s", v)
+			}
+		}
+	})
+}
+
+func TestParseRegions(t *testing.T) {
+	if r, err := parseRegions(""); err != nil || len(r) != 1 || r[0] != "" {
+		t.Errorf("unexpected default regions: %v, %v", r, err)
+	}
+
+	if r, err := parseRegions("us-east-1,eu-west-1"); err != nil || strings.Join(r, " ") != "us-east-1 eu-west-1" {
+		t.Errorf("unexpected regions: %v, %v", r, err)
+	}
+
+	for _, v := range []string{"us-east-1,", "us-east-1,us-east-1"} {
+		if _, err := parseRegions(v); err == nil {
+			t.Errorf("did not receive expected error for %s", v)
+		}
+	}
 }
 
 func TestValidateKey(t *testing.T) {
@@ -149,6 +224,81 @@ func TestValidateKey(t *testing.T) {
 	})
 }
 
+func TestResolveKeys(t *testing.T) {
+	defer func() {
+		kmsKeyArg = ""
+		kmsKeyMapArg = make(mapValue)
+	}()
+
+	t.Run("key and map", func(t *testing.T) {
+		m := new(mockKmsClient)
+		b := NewParameterStoreBackend()
+		sb = b
+
+		kmsKeyArg = "alias/default"
+		kmsKeyMapArg = mapValue{"/team-a/": "alias/team-a", "/team-a/x/": "alias/team-a", "/team-b/": "team-b"}
+
+		if err := resolveKeys(newKmsKeyResolver(m), target{}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if b.kmsKey != "arn:aws:kms:us-east-1:012345678901:key/default" || len(b.kmsKeyMap) != 3 {
+			t.Errorf("unexpected keys: %s %v", b.kmsKey, b.kmsKeyMap)
+		}
+
+		if b.kmsKeyMap["/team-a/x/"] != "arn:aws:kms:us-east-1:012345678901:key/team-a" {
+			t.Errorf("unexpected key map: %v", b.kmsKeyMap)
+		}
+
+		if m.describes != 3 {
+			t.Errorf("unexpected number of DescribeKey calls: %d", m.describes)
+		}
+	})
+
+	t.Run("regions", func(t *testing.T) {
+		east := NewParameterStoreBackend()
+		west := NewParameterStoreBackend()
+
+		targets = []target{{name: "ssm", region: "us-east-1", SecretBackender: east}, {name: "ssm", region: "us-west-2", SecretBackender: west}}
+		defer func() { targets = nil }()
+
+		kmsKeyArg = "arn:aws:kms:us-east-1:012345678901:key/mrk-1234"
+		kmsKeyMapArg = make(mapValue)
+
+		if err := resolveKeys(newKmsKeyResolver(new(mockKmsClient)), target{region: "us-west-2"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(east.kmsKey) > 0 {
+			t.Errorf("key set in the wrong region: %s", east.kmsKey)
+		}
+
+		if west.kmsKey != "arn:aws:kms:us-west-2:012345678901:key/mrk-1234" {
+			t.Errorf("unexpected key: %s", west.kmsKey)
+		}
+	})
+
+	t.Run("bad map key", func(t *testing.T) {
+		kmsKeyArg = ""
+		kmsKeyMapArg = mapValue{"/team-a/": "missing"}
+
+		if err := resolveKeys(newKmsKeyResolver(new(mockKmsClient)), target{}); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		kmsKeyArg = ""
+		kmsKeyMapArg = make(mapValue)
+
+		if err := resolveKeys(newKmsKeyResolver(new(mockKmsClient)), target{}); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
+
 func TestBackendFactory(t *testing.T) {
 	t.Run("dynamodb", func(t *testing.T) {
 		t.Skip("requires setting mock dynamodb client")
@@ -156,21 +306,32 @@ func TestBackendFactory(t *testing.T) {
 
 	t.Run("dynamodb no table", func(t *testing.T) {
 		dynamoTableArg = ""
-		if err := backendFactory("dynamodb"); err == nil {
+		if err := backendFactory("dynamodb", ses); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
+
+	t.Run("dynamodb bad sort key value", func(t *testing.T) {
+		dynamoTableArg = "my-table"
+		sortKeyArg = "latest"
+		defer func() { dynamoTableArg, sortKeyArg = "", "" }()
+
+		if err := backendFactory("dynamodb", ses); err == nil {
 			t.Error("did not receive expected error")
 			return
 		}
 	})
 
 	t.Run("secretsmanager", func(t *testing.T) {
-		if err := backendFactory("secretsmanager"); err != nil {
+		if err := backendFactory("secretsmanager", ses); err != nil {
 			t.Error(err)
 			return
 		}
 	})
 
 	t.Run("ssm", func(t *testing.T) {
-		if err := backendFactory("ssm"); err != nil {
+		if err := backendFactory("ssm", ses); err != nil {
 			t.Error(err)
 			return
 		}
@@ -178,7 +339,7 @@ func TestBackendFactory(t *testing.T) {
 
 	t.Run("s3", func(t *testing.T) {
 		bucketArg = "my-bucket"
-		if err := backendFactory("s3"); err != nil {
+		if err := backendFactory("s3", ses); err != nil {
 			t.Error(err)
 			return
 		}
@@ -186,14 +347,58 @@ func TestBackendFactory(t *testing.T) {
 
 	t.Run("s3 no bucket", func(t *testing.T) {
 		bucketArg = ""
-		if err := backendFactory("s3"); err == nil {
+		if err := backendFactory("s3", ses); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
+
+	t.Run("file bad key", func(t *testing.T) {
+		fileArg = "secrets.json"
+		os.Setenv("FILE_KEY_FILE", "testdata/missing")
+		defer os.Unsetenv("FILE_KEY_FILE")
+
+		if err := backendFactory("file", ses); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
+
+	t.Run("file no key", func(t *testing.T) {
+		fileArg = "secrets.json"
+		if err := backendFactory("file", ses); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
+
+	t.Run("file no path", func(t *testing.T) {
+		fileArg = ""
+		if err := backendFactory("file", ses); err == nil {
+			t.Error("did not receive expected error")
+			return
+		}
+	})
+
+	t.Run("vault", func(t *testing.T) {
+		os.Setenv("VAULT_TOKEN", "s.token")
+		defer os.Unsetenv("VAULT_TOKEN")
+
+		if err := backendFactory("vault", ses); err != nil {
+			t.Error(err)
+			return
+		}
+	})
+
+	t.Run("vault no credentials", func(t *testing.T) {
+		if err := backendFactory("vault", ses); err == nil {
 			t.Error("did not receive expected error")
 			return
 		}
 	})
 
 	t.Run("invalid", func(t *testing.T) {
-		if err := backendFactory("invalid"); err == nil {
+		if err := backendFactory("invalid", ses); err == nil {
 			t.Error("did not receive expected error")
 			return
 		}
@@ -351,6 +556,57 @@ func TestOneShotHandler(t *testing.T) {
 			t.Error("did not receive expected error")
 		}
 	})
+
+	t.Run("compare", func(t *testing.T) {
+		m := newMockBackend()
+		m.data["my-key"] = []byte("my-value")
+		sb = m
+
+		compareArg = true
+		defer func() { compareArg = false }()
+
+		if err := oneShotHandler("my-key", "my-value"); err != nil || m.stores > 0 {
+			t.Errorf("unchanged value was stored: %v", err)
+		}
+
+		if err := oneShotHandler("my-key", strings.NewReader("my-value")); err != nil || m.stores > 0 {
+			t.Errorf("unchanged value was stored: %v", err)
+		}
+
+		if err := oneShotHandler("my-key", strings.NewReader("new-value")); err != nil || string(m.data["my-key"]) != "new-value" {
+			t.Errorf("changed value was not stored: %v", err)
+		}
+	})
+}
+
+func TestGetHandler(t *testing.T) {
+	m := newMockBackend()
+	m.data["my-key"] = []byte("my-value")
+	sb = m
+
+	t.Run("good", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		if err := getHandler("my-key", b); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if b.String() != "my-value" {
+			t.Errorf("unexpected value: %s", b.String())
+		}
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		if err := getHandler("", new(bytes.Buffer)); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if err := getHandler("missing", new(bytes.Buffer)); err != ErrSecretNotFound {
+			t.Errorf("did not receive expected error, got: %v", err)
+		}
+	})
 }
 
 func TestJsonHandler(t *testing.T) {
@@ -407,3 +663,503 @@ func TestJsonHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestPlanHandler(t *testing.T) {
+	m := newMockBackend()
+	m.data["same"] = []byte("value")
+	m.data["changed"] = []byte("old")
+	sb = m
+
+	t.Run("good", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		if errs := planHandler(`{"same": "value", "changed": "new value", "new": "value"}`, b); errs > 0 {
+			t.Error("got an error when planning a known good value")
+			return
+		}
+
+		expected := "update    changed (3 bytes -> 9 bytes)\ncreate    new (5 bytes)\nunchanged same\n"
+		if b.String() != expected {
+			t.Errorf("unexpected plan output:\n%s", b.String())
+		}
+
+		if string(m.data["changed"]) != "old" {
+			t.Error("plan modified a stored value")
+		}
+
+		if _, ok := m.data["new"]; ok {
+			t.Error("plan stored a new value")
+		}
+	})
+
+	t.Run("value not in output", func(t *testing.T) {
+		b := new(bytes.Buffer)
+		planHandler(`{"changed": "super-secret"}`, b)
+
+		if strings.Contains(b.String(), "super-secret") || strings.Contains(b.String(), "old") {
+			t.Errorf("secret value found in plan output: %s", b.String())
+		}
+	})
+
+	t.Run("bad json", func(t *testing.T) {
+		if errs := planHandler("this is not json", new(bytes.Buffer)); errs < 1 {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("invalid key name", func(t *testing.T) {
+		targets = []target{{name: ssmSvc, SecretBackender: m}}
+		defer func() { targets = nil }()
+
+		b := new(bytes.Buffer)
+		if errs := planHandler(`{"/app/ok": "value", "/aws/key": "value"}`, b); errs != 1 {
+			t.Errorf("unexpected number of errors: %d", errs)
+		}
+
+		if !strings.Contains(b.String(), "create    /app/ok") {
+			t.Errorf("unexpected plan output:\n%s", b.String())
+		}
+	})
+}
+
+func TestJsonHandler_Compare(t *testing.T) {
+	compareArg = true
+	defer func() { compareArg = false }()
+
+	m := newMockBackend()
+	m.data["same"] = []byte("value")
+	m.data["changed"] = []byte("old")
+	sb = m
+
+	if errs := jsonHandler(`{"same": "value", "changed": "new value", "new": "value"}`); errs > 0 {
+		t.Error("got an error when storing a known good value")
+		return
+	}
+
+	if m.stores != 2 {
+		t.Errorf("unexpected number of stores: %d", m.stores)
+	}
+
+	if string(m.data["changed"]) != "new value" {
+		t.Errorf("changed value was not stored")
+	}
+}
+
+func TestMultipleBackends(t *testing.T) {
+	a := newMockBackend()
+	b := newMockBackend()
+	b.err = fmt.Errorf("access denied")
+
+	targets = []target{{name: "a", SecretBackender: a}, {name: "b", SecretBackender: b}}
+	defer func() { targets = nil }()
+
+	t.Run("json", func(t *testing.T) {
+		if errs := jsonHandler(`{"k1": "v1", "k2": "v2"}`); errs != 2 {
+			t.Errorf("unexpected number of errors: %d", errs)
+		}
+
+		if a.stores != 2 {
+			t.Errorf("secrets not stored in all backends: %v", a.data)
+		}
+	})
+
+	t.Run("one-shot", func(t *testing.T) {
+		b.err = nil
+		if err := oneShotHandler("k3", strings.NewReader("v3")); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if string(a.data["k3"]) != "v3" || string(b.data["k3"]) != "v3" {
+			t.Errorf("value not stored in all backends: %s, %s", a.data["k3"], b.data["k3"])
+		}
+
+		b.err = fmt.Errorf("access denied")
+		if err := oneShotHandler("k4", "v4"); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		w := new(bytes.Buffer)
+		if errs := planHandler(`{"k1": "v1"}`, w); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+			return
+		}
+
+		expected := "a backend:\nunchanged k1\nb backend:\ncreate    k1 (2 bytes)\n"
+		if w.String() != expected {
+			t.Errorf("unexpected plan output:\n%s", w.String())
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		b.data["/app/old"] = []byte("x")
+		a.data["/app/old"] = []byte("x")
+
+		if errs := pruneSecrets([]*secret{{key: "/app/new"}}, "/app/", false, nil); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+		}
+
+		if _, ok := a.data["/app/old"]; ok {
+			t.Error("key not pruned from first backend")
+		}
+
+		if _, ok := b.data["/app/old"]; ok {
+			t.Error("key not pruned from second backend")
+		}
+	})
+}
+
+func TestJsonHandler_InvalidKeyNames(t *testing.T) {
+	b := newMockBackend()
+	targets = []target{{name: ssmSvc, SecretBackender: b}}
+	defer func() { targets = nil }()
+
+	if errs := jsonHandler(`{"/app/a": "1", "/app/b c": "2", "/aws/d": "3", "/app/e": "4"}`); errs != 2 {
+		t.Errorf("unexpected number of errors: %d", errs)
+	}
+
+	if b.stores > 0 {
+		t.Errorf("secrets stored with invalid key names in the input: %v", b.data)
+	}
+}
+
+func TestJsonHandler_MissingKmsKey(t *testing.T) {
+	b := newMockBackend()
+	b.kmsRequired = true
+	targets = []target{{name: dynamoSvc, SecretBackender: b}}
+
+	kmsKeyMapArg = mapValue{"/team-a/": "alias/team-a"}
+	defer func() {
+		targets = nil
+		kmsKeyMapArg = make(mapValue)
+	}()
+
+	if errs := jsonHandler(`{"/team-a/a": "1", "/team-b/b": "2"}`); errs != 1 {
+		t.Errorf("unexpected number of errors: %d", errs)
+	}
+
+	if err := oneShotHandler("/team-b/b", "2"); err == nil {
+		t.Error("did not receive expected error")
+	}
+
+	if b.stores > 0 {
+		t.Errorf("secrets stored without a KMS key: %v", b.data)
+	}
+}
+
+func TestKeyNames(t *testing.T) {
+	ssmB := newMockBackend()
+	smB := newMockBackend()
+
+	targets = []target{{name: ssmSvc, SecretBackender: ssmB}, {name: secretsSvc, SecretBackender: smB}}
+	namer, _ = newKeyNamer("", "{{env}}/{{key}}", map[string]string{"env": "prod"})
+	defer func() {
+		targets = nil
+		namer = nil
+	}()
+
+	t.Run("json", func(t *testing.T) {
+		if errs := jsonHandler(`{"db_password": "x"}`); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+			return
+		}
+
+		if _, ok := ssmB.data["/prod/db_password"]; !ok {
+			t.Errorf("unexpected ssm keys: %v", ssmB.data)
+		}
+
+		if _, ok := smB.data["prod/db_password"]; !ok {
+			t.Errorf("unexpected secretsmanager keys: %v", smB.data)
+		}
+	})
+
+	t.Run("one-shot", func(t *testing.T) {
+		if err := oneShotHandler("api_key", "y"); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, ok := ssmB.data["/prod/api_key"]; !ok {
+			t.Errorf("unexpected ssm keys: %v", ssmB.data)
+		}
+
+		if _, ok := smB.data["prod/api_key"]; !ok {
+			t.Errorf("unexpected secretsmanager keys: %v", smB.data)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		ssmB.data["/prod/api_key"] = []byte("from ssm")
+
+		w := new(bytes.Buffer)
+		if err := getHandler("api_key", w); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if w.String() != "from ssm" {
+			t.Errorf("unexpected value: %s", w.String())
+		}
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		w := new(bytes.Buffer)
+		if errs := planHandler(`{"db_password": "x", "new_key": "z"}`, w); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+			return
+		}
+
+		for _, v := range []string{"unchanged /prod/db_password", "create    prod/new_key (1 bytes)"} {
+			if !strings.Contains(w.String(), v) {
+				t.Errorf("missing %q in plan:\n%s", v, w.String())
+			}
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		if errs := pruneSecrets([]*secret{{key: "prod/db_password"}}, "prod/", false, nil); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+		}
+
+		if len(ssmB.data) != 1 || len(smB.data) != 1 {
+			t.Errorf("unexpected keys after prune: %v %v", ssmB.data, smB.data)
+		}
+	})
+}
+
+func TestRoleMapTargets(t *testing.T) {
+	def := newMockBackend()
+	teamA := newMockBackend()
+	teamAX := newMockBackend()
+
+	targets = []target{
+		{name: "ssm", SecretBackender: def},
+		{name: "ssm", prefix: "/team-a/", SecretBackender: teamA},
+		{name: "ssm", prefix: "/team-a/x/", SecretBackender: teamAX},
+	}
+	defer func() { targets = nil }()
+
+	if errs := jsonHandler(`{"/shared/k": "1", "/team-a/k": "2", "/team-a/x/k": "3"}`); errs > 0 {
+		t.Errorf("unexpected number of errors: %d", errs)
+		return
+	}
+
+	for b, want := range map[*mockBackend]string{def: "/shared/k", teamA: "/team-a/k", teamAX: "/team-a/x/k"} {
+		if _, ok := b.data[want]; !ok || len(b.data) != 1 {
+			t.Errorf("unexpected keys stored for %s: %v", want, b.data)
+		}
+	}
+
+	t.Run("get", func(t *testing.T) {
+		w := new(bytes.Buffer)
+		if err := getHandler("/team-a/x/k", w); err != nil || w.String() != "3" {
+			t.Errorf("unexpected get result %s: %v", w.String(), err)
+		}
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		// keys owned by another role are never pruned by the default role
+		def.data["/team-a/old"] = []byte("x")
+		teamA.data["/team-a/old"] = []byte("x")
+
+		if errs := pruneSecrets([]*secret{{key: "/team-a/k"}}, "/team-a/", false, nil); errs > 0 {
+			t.Errorf("unexpected number of errors: %d", errs)
+		}
+
+		if _, ok := def.data["/team-a/old"]; !ok {
+			t.Error("key pruned from the default role")
+		}
+
+		if _, ok := teamA.data["/team-a/old"]; ok {
+			t.Error("key not pruned from the mapped role")
+		}
+	})
+}
+
+func TestJsonHandler_Records(t *testing.T) {
+	// the mock backend does not store metadata, so the values are stored and the metadata is ignored
+	m := newMockBackend()
+	sb = m
+
+	if errs := jsonHandler(`{"_schema": "record", "a": {"value": "1", "description": "ignored"}, "b": {"value": {"k": "v"}}}`); errs > 0 {
+		t.Error("got an error when storing a known good value")
+		return
+	}
+
+	if string(m.data["a"]) != "1" || string(m.data["b"]) != `{"k":"v"}` {
+		t.Errorf("unexpected stored values: %v", m.data)
+	}
+}
+
+func TestPruneSecrets(t *testing.T) {
+	newBackend := func() *mockBackend {
+		m := newMockBackend()
+		for _, k := range []string{"/app/keep", "/app/stale", "/other/stale"} {
+			m.data[k] = []byte("value")
+		}
+		return m
+	}
+	secrets := []*secret{{key: "/app/keep", value: "value"}, {key: "/other/new", value: "value"}}
+
+	t.Run("delete", func(t *testing.T) {
+		m := newBackend()
+		sb = m
+
+		if errs := pruneSecrets(secrets, "/app/", false, nil); errs > 0 {
+			t.Error("got an error when pruning secrets")
+			return
+		}
+
+		if _, ok := m.data["/app/stale"]; ok {
+			t.Error("stale key was not deleted")
+		}
+
+		if _, ok := m.data["/app/keep"]; !ok {
+			t.Error("key in input was deleted")
+		}
+
+		if _, ok := m.data["/other/stale"]; !ok {
+			t.Error("key outside of prefix was deleted")
+		}
+	})
+
+	t.Run("preview", func(t *testing.T) {
+		m := newBackend()
+		sb = m
+
+		b := new(bytes.Buffer)
+		if errs := pruneSecrets(secrets, "/app/", true, b); errs > 0 {
+			t.Error("got an error when previewing prune")
+			return
+		}
+
+		if b.String() != "delete    /app/stale\n" {
+			t.Errorf("unexpected preview output: %s", b.String())
+		}
+
+		if len(m.data) != 3 {
+			t.Error("preview deleted a key")
+		}
+	})
+
+	t.Run("empty input", func(t *testing.T) {
+		m := newBackend()
+		sb = m
+
+		if errs := pruneSecrets([]*secret{}, "/app/", false, nil); errs < 1 {
+			t.Error("did not receive expected error")
+		}
+
+		if len(m.data) != 3 {
+			t.Error("empty input deleted a key")
+		}
+	})
+}
+
+func TestJsonHandler_Concurrency(t *testing.T) {
+	concurrencyArg = 4
+	defer func() { concurrencyArg = 1 }()
+
+	m := newMockBackend()
+	sb = m
+
+	b := new(bytes.Buffer)
+	b.WriteString("{")
+	for i := 0; i < 100; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(b, `"key-%d": "value-%d"`, i, i)
+	}
+	b.WriteString(`, "empty": ""}`)
+
+	if errs := jsonHandler(b.String()); errs != 1 {
+		t.Errorf("unexpected error count: %d", errs)
+		return
+	}
+
+	if m.stores != 100 {
+		t.Errorf("unexpected number of stores: %d", m.stores)
+	}
+
+	t.Run("repeated key", func(t *testing.T) {
+		m := newMockBackend()
+		sb = m
+
+		if errs := jsonHandler(`{"k": "1", "a": "x"} {"k": "2"} {"b": "y", "k": "3"}`); errs > 0 {
+			t.Errorf("unexpected error count: %d", errs)
+			return
+		}
+
+		if m.stores != 3 || string(m.data["k"]) != "3" {
+			t.Errorf("unexpected stores: %d %v", m.stores, m.data)
+		}
+	})
+}
+
+func TestCheckIntEnv(t *testing.T) {
+	t.Run("set", func(t *testing.T) {
+		os.Setenv("MY_VAR", "5")
+		defer os.Unsetenv("MY_VAR")
+
+		if checkIntEnv("MY_VAR", 1) != 5 {
+			t.Error("unexpected value")
+		}
+	})
+
+	t.Run("not set", func(t *testing.T) {
+		if checkIntEnv("MY_VAR", 1) != 1 {
+			t.Error("unexpected value")
+		}
+	})
+
+	t.Run("not an int", func(t *testing.T) {
+		os.Setenv("MY_VAR", "x")
+		defer os.Unsetenv("MY_VAR")
+
+		if checkIntEnv("MY_VAR", 1) != 1 {
+			t.Error("unexpected value")
+		}
+	})
+}
+
+func TestOneShotHandler_MultipleBackends(t *testing.T) {
+	ssmC := new(mockSsmClient)
+	smC := new(mockSecretsManagerClient)
+
+	ssmB := NewParameterStoreBackend()
+	ssmB.c = ssmC
+	smB := NewSecretsManagerBackend()
+	smB.c = smC
+
+	targets = []target{
+		{name: ssmSvc, region: "us-east-1", SecretBackender: ssmB},
+		{name: ssmSvc, region: "us-west-2", SecretBackender: ssmB},
+		{name: secretsSvc, SecretBackender: smB},
+	}
+	defer func() { targets = nil }()
+
+	if err := oneShotHandler("/my/secret", "shhhh"); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if ssmC.params["/my/secret"] != "shhhh" {
+		t.Errorf("unexpected ssm value: %v", ssmC.params)
+	}
+
+	if v := smC.secrets["/my/secret"]; v == nil || aws.StringValue(v.SecretString) != "shhhh" || v.SecretBinary != nil {
+		t.Errorf("value not stored as a SecretString: %v", v)
+	}
+
+	t.Run("reader", func(t *testing.T) {
+		if err := oneShotHandler("/my/binary", strings.NewReader("binary")); err == nil {
+			t.Error("did not receive expected error from the ssm backend")
+		}
+
+		if v := smC.secrets["/my/binary"]; v == nil || string(v.SecretBinary) != "binary" {
+			t.Errorf("reader value not stored in every backend: %v", v)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// mapValue is a flag.Value which collects the key=value pairs from repeated options, like -tag
+type mapValue map[string]string
+
+// String returns a comma-separated list of the key=value pairs, sorted by key
+func (t mapValue) String() string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + t[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set adds the pairs in v, which is parsed using parsePairs(), replacing any existing values for the same keys
+func (t mapValue) Set(v string) error {
+	m, err := parsePairs(v)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range m {
+		t[k] = v
+	}
+	return nil
+}
+
+// parsePairs parses a comma-separated list of key=value pairs.  AWS does not allow commas in tag keys or values,
+// or KMS key ARNs, however the '=' character is allowed in tag values, so only the first '=' in each pair is used
+// as the separator.
+func parsePairs(s string) (map[string]string, error) {
+	m := make(map[string]string)
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if len(p) < 1 {
+			continue
+		}
+
+		i := strings.Index(p, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid value %s, must be key=value", p)
+		}
+		m[strings.TrimSpace(p[:i])] = strings.TrimSpace(p[i+1:])
+	}
+
+	return m, nil
+}
+
+// mergeTags returns a new map with the tags in b added to the tags in a, replacing the values of any duplicate keys
+func mergeTags(a, b map[string]string) map[string]string {
+	m := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestParsePairs(t *testing.T) {
+	t.Run("good", func(t *testing.T) {
+		m, err := parsePairs(" team=ops, ,expr=a=b,empty=")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(m) != 3 || m["team"] != "ops" || m["expr"] != "a=b" || m["empty"] != "" {
+			t.Errorf("unexpected tags: %v", m)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		m, err := parsePairs("")
+		if err != nil || len(m) > 0 {
+			t.Errorf("unexpected result: %v %v", m, err)
+		}
+	})
+
+	t.Run("bad", func(t *testing.T) {
+		for _, s := range []string{"team", "=ops"} {
+			if _, err := parsePairs(s); err == nil {
+				t.Errorf("did not receive expected error for %s", s)
+			}
+		}
+	})
+}
+
+func TestMapValue(t *testing.T) {
+	v := make(mapValue)
+
+	f := flag.NewFlagSet("test", flag.ContinueOnError)
+	f.Var(v, "tag", "")
+
+	if err := f.Parse([]string{"-tag", "b=2", "-tag", "a=1,b=3"}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v.String() != "a=1,b=3" {
+		t.Errorf("unexpected tags: %s", v)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	a := map[string]string{"a": "1", "b": "2"}
+	m := mergeTags(a, map[string]string{"b": "3"})
+
+	if len(m) != 2 || m["b"] != "3" || a["b"] != "2" {
+		t.Errorf("unexpected tags: %v %v", m, a)
+	}
+}
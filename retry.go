@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// throttleCodes are AWS error codes which indicate the request was rate limited, in addition to the
+// codes the SDK already recognises.  These are retried using the longer throttling delays.
+var throttleCodes = map[string]bool{
+	"TooManyUpdates":                         true, // SSM PutParameter on the same parameter
+	"ThrottlingException":                    true,
+	"TooManyRequestsException":               true,
+	"RequestLimitExceeded":                   true,
+	"SlowDown":                               true,
+	"ProvisionedThroughputExceededException": true,
+}
+
+// retryableCodes are AWS error codes for transient failures which are not caused by rate limiting,
+// in addition to the codes the SDK already recognises.
+var retryableCodes = map[string]bool{
+	"DependencyTimeoutException": true, // KMS
+	"KMSInternalException":       true,
+	"InternalServerError":        true,
+	"InternalFailure":            true,
+	"InternalServiceError":       true, // Secrets Manager
+	"ServiceUnavailable":         true,
+}
+
+// retryer retries failed AWS requests using exponential backoff with full jitter, classifying the
+// additional error codes in throttleCodes and retryableCodes as retryable.
+type retryer struct {
+	client.DefaultRetryer
+}
+
+// newRetryer creates a retryer which will make at most attempts calls for each AWS request
+func newRetryer(attempts int) *retryer {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return &retryer{client.DefaultRetryer{
+		NumMaxRetries:    attempts - 1,
+		MinRetryDelay:    client.DefaultRetryerMinRetryDelay,
+		MaxRetryDelay:    20 * time.Second,
+		MinThrottleDelay: client.DefaultRetryerMinThrottleDelay,
+		MaxThrottleDelay: 20 * time.Second,
+	}}
+}
+
+// ShouldRetry returns true if the request failed with an error which is known to be retryable
+func (r *retryer) ShouldRetry(req *request.Request) bool {
+	if isThrottle(req.Error) || isRetryable(req.Error) {
+		return true
+	}
+	return r.DefaultRetryer.ShouldRetry(req)
+}
+
+// RetryRules returns a random delay between 0 and the exponential backoff delay for the retry attempt,
+// using the longer throttling delays if the request was rate limited
+func (r *retryer) RetryRules(req *request.Request) time.Duration {
+	min, max := r.MinRetryDelay, r.MaxRetryDelay
+	if isThrottle(req.Error) || req.IsErrorThrottle() {
+		min, max = r.MinThrottleDelay, r.MaxThrottleDelay
+	}
+
+	return backoff(min, max, req.RetryCount)
+}
+
+// full jitter exponential backoff, see https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func backoff(min, max time.Duration, attempt int) time.Duration {
+	d := float64(min) * math.Pow(2, float64(attempt))
+	if d > float64(max) || d <= 0 {
+		d = float64(max)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func isThrottle(err error) bool {
+	if e, ok := err.(awserr.Error); ok {
+		return throttleCodes[e.Code()]
+	}
+	return false
+}
+
+func isRetryable(err error) bool {
+	if e, ok := err.(awserr.Error); ok {
+		return retryableCodes[e.Code()]
+	}
+	return false
+}
+
+// rateLimiter is a token bucket which allows up to rate requests per second, with bursts of up to burst requests
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	burst := math.Max(1, rate)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available in the bucket
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens < 1 {
+		d := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		time.Sleep(d)
+
+		l.tokens = 1
+		l.last = time.Now()
+	}
+	l.tokens--
+}
+
+// withRetries returns a copy of the session which retries failed requests up to the provided number of attempts
+func withRetries(s *session.Session, attempts int) *session.Session {
+	c := s.Copy()
+	c.Config.Retryer = newRetryer(attempts)
+	return c
+}
+
+// withRateLimit returns a copy of the session which limits the rate of requests (including retries) sent by all
+// clients created from the copy to rate per second.  Each copy has its own limit, so a copy is made for each
+// backend.  A rate of 0 disables the rate limit.
+func withRateLimit(s *session.Session, rate float64) *session.Session {
+	if rate <= 0 {
+		return s
+	}
+
+	c := s.Copy()
+	l := newRateLimiter(rate)
+	c.Handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: "aws-secrets-sync.RateLimit",
+		Fn:   func(*request.Request) { l.wait() },
+	})
+
+	return c
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryer_ShouldRetry(t *testing.T) {
+	r := newRetryer(4)
+
+	tests := map[string]bool{
+		"TooManyUpdates":             true,
+		"ThrottlingException":        true,
+		"DependencyTimeoutException": true,
+		"ParameterNotFound":          false,
+		"AccessDeniedException":      false,
+	}
+
+	for code, want := range tests {
+		t.Run(code, func(t *testing.T) {
+			req := &request.Request{Error: awserr.New(code, "test", nil), HTTPResponse: &http.Response{StatusCode: 400}}
+			if r.ShouldRetry(req) != want {
+				t.Errorf("expected ShouldRetry() to be %v", want)
+			}
+		})
+	}
+}
+
+func TestRetryer_RetryRules(t *testing.T) {
+	r := newRetryer(10)
+
+	t.Run("throttle", func(t *testing.T) {
+		req := &request.Request{Error: awserr.New("TooManyUpdates", "test", nil), RetryCount: 20}
+		if d := r.RetryRules(req); d < 0 || d > r.MaxThrottleDelay {
+			t.Errorf("delay out of range: %s", d)
+		}
+	})
+
+	t.Run("transient", func(t *testing.T) {
+		req := &request.Request{Error: awserr.New("InternalFailure", "test", nil), RetryCount: 1}
+		if d := r.RetryRules(req); d < 0 || d > 2*r.MinRetryDelay {
+			t.Errorf("delay out of range: %s", d)
+		}
+	})
+}
+
+func TestNewRetryer(t *testing.T) {
+	if r := newRetryer(0); r.MaxRetries() != 0 {
+		t.Errorf("unexpected max retries: %d", r.MaxRetries())
+	}
+
+	if r := newRetryer(5); r.MaxRetries() != 4 {
+		t.Errorf("unexpected max retries: %d", r.MaxRetries())
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(20)
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		l.wait()
+	}
+
+	// the first 20 are the burst, the next 10 should take 0.5 seconds
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("rate limit not applied, took %s", d)
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"__type": "TooManyUpdates", "message": "slow down"}`)
+			return
+		}
+		fmt.Fprint(w, `{"Version": 1}`)
+	}))
+	defer srv.Close()
+
+	base := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1").WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+
+	s := withRateLimit(withRetries(base, 3), 100)
+	s.Config.Retryer.(*retryer).MinThrottleDelay = time.Millisecond
+
+	if base.Config.Retryer != nil {
+		t.Error("original session was modified")
+	}
+
+	_, err := ssm.New(s).PutParameter(&ssm.PutParameterInput{
+		Name: aws.String("key"), Value: aws.String("value"), Type: aws.String(ssm.ParameterTypeString),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if calls != 3 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprint(w, `{"Version": 1}`)
+	}))
+	defer srv.Close()
+
+	base := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1").WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+
+	if s := withRateLimit(base, 0); s != base {
+		t.Error("session copied without a rate limit")
+	}
+
+	a := withRateLimit(base, 1)
+	b := withRateLimit(base, 1)
+
+	if base.Handlers.Send.Len() != a.Handlers.Send.Len()-1 {
+		t.Error("original session was modified")
+	}
+
+	// with a burst of 1, a shared limit would delay the second request by a second
+	start := time.Now()
+	for _, s := range []*session.Session{a, b} {
+		_, err := ssm.New(s).PutParameter(&ssm.PutParameterInput{
+			Name: aws.String("key"), Value: aws.String("value"), Type: aws.String(ssm.ParameterTypeString),
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("rate limit shared between sessions, took %s", d)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// the default session name for assumed roles, which shows in CloudTrail as the name of the caller
+const defaultRoleSessionName = "aws-secrets-sync"
+
+// roleOptions are the options used when assuming an IAM role
+type roleOptions struct {
+	externalID  string
+	sessionName string
+	duration    time.Duration
+}
+
+// assumeRole returns a copy of the session which uses the credentials of the role.  The role is assumed using the
+// credentials of the provided session the first time the credentials are needed, and refreshed before they expire.
+// The STS client uses the custom STS endpoint, if one was provided.
+func assumeRole(s *session.Session, roleArn string, o roleOptions) (*session.Session, error) {
+	a, err := arn.Parse(roleArn)
+	if err != nil || a.Service != "iam" || !strings.HasPrefix(a.Resource, "role/") {
+		return nil, fmt.Errorf("invalid role ARN %s", roleArn)
+	}
+
+	c := stscreds.NewCredentialsWithClient(sts.New(s, endpointConfig(sts.EndpointsID)), roleArn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = defaultRoleSessionName
+		if len(o.sessionName) > 0 {
+			p.RoleSessionName = o.sessionName
+		}
+
+		if len(o.externalID) > 0 {
+			p.ExternalID = aws.String(o.externalID)
+		}
+
+		if o.duration > 0 {
+			p.Duration = o.duration
+		}
+	})
+
+	return s.Copy(aws.NewConfig().WithCredentials(c)), nil
+}
+
+// roleSessions returns the session to use for each key name prefix, and the sorted prefixes.  The empty prefix
+// uses the role, or the provided session if role is empty.  Each prefix in the role map uses the mapped role,
+// which is assumed using the credentials of the provided session.
+func roleSessions(s *session.Session, role string, roleMap map[string]string, o roleOptions) (map[string]*session.Session, []string, error) {
+	sessions := map[string]*session.Session{"": s}
+	prefixes := []string{""}
+
+	if len(role) > 0 {
+		r, err := assumeRole(s, role, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		sessions[""] = r
+	}
+
+	for p, v := range roleMap {
+		if len(p) < 1 {
+			return nil, nil, fmt.Errorf("empty key name prefix for role %s", v)
+		}
+
+		r, err := assumeRole(s, v, o)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sessions[p] = r
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	return sessions, prefixes, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssumeRole(t *testing.T) {
+	var form url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.Form
+
+		fmt.Fprint(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult>
+<Credentials><AccessKeyId>AKIDROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>2030-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`)
+	}))
+	defer srv.Close()
+
+	base := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1").WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+
+	t.Run("good", func(t *testing.T) {
+		o := roleOptions{externalID: "ext-id", duration: time.Hour}
+		s, err := assumeRole(base, "arn:aws:iam::012345678901:role/writer", o)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		v, err := s.Config.Credentials.Get()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if v.AccessKeyID != "AKIDROLE" {
+			t.Errorf("unexpected credentials: %s", v.AccessKeyID)
+		}
+
+		if form.Get("RoleArn") != "arn:aws:iam::012345678901:role/writer" || form.Get("ExternalId") != "ext-id" ||
+			form.Get("RoleSessionName") != defaultRoleSessionName || form.Get("DurationSeconds") != "3600" {
+			t.Errorf("unexpected AssumeRole request: %v", form)
+		}
+
+		if v, _ := base.Config.Credentials.Get(); v.AccessKeyID != "AKID" {
+			t.Error("original session was modified")
+		}
+	})
+
+	t.Run("sts endpoint", func(t *testing.T) {
+		if err := setEndpoints(map[string]string{"sts": srv.URL}); err != nil {
+			t.Error(err)
+			return
+		}
+		defer setEndpoints(nil)
+
+		form = nil
+		s := session.Must(session.NewSession(aws.NewConfig().WithRegion("us-east-1").
+			WithCredentials(credentials.NewStaticCredentials("AKID", "SECRET", ""))))
+
+		r, err := assumeRole(s, "arn:aws:iam::012345678901:role/writer", roleOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if v, err := r.Config.Credentials.Get(); err != nil || v.AccessKeyID != "AKIDROLE" || form == nil {
+			t.Errorf("custom STS endpoint not used: %v", err)
+		}
+	})
+
+	t.Run("bad arn", func(t *testing.T) {
+		for _, v := range []string{"writer", "arn:aws:iam::012345678901:user/writer", "arn:aws:kms:us-east-1:012345678901:key/x"} {
+			if _, err := assumeRole(base, v, roleOptions{}); err == nil {
+				t.Errorf("did not receive expected error for %s", v)
+			}
+		}
+	})
+}
+
+func TestRoleSessions(t *testing.T) {
+	base := session.Must(session.NewSession())
+	m := map[string]string{"/team-b/": "arn:aws:iam::012345678901:role/b", "/team-a/": "arn:aws:iam::210987654321:role/a"}
+
+	t.Run("role map", func(t *testing.T) {
+		s, p, err := roleSessions(base, "", m, roleOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if strings.Join(p, ",") != ",/team-a/,/team-b/" {
+			t.Errorf("unexpected prefixes: %v", p)
+		}
+
+		if s[""] != base || s["/team-a/"] == base || s["/team-a/"] == s["/team-b/"] {
+			t.Errorf("unexpected sessions: %v", s)
+		}
+	})
+
+	t.Run("default role", func(t *testing.T) {
+		s, p, err := roleSessions(base, "arn:aws:iam::012345678901:role/default", nil, roleOptions{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if len(p) != 1 || s[""] == base {
+			t.Errorf("default role not used: %v", s)
+		}
+	})
+
+	t.Run("bad role", func(t *testing.T) {
+		if _, _, err := roleSessions(base, "", map[string]string{"/x/": "bad"}, roleOptions{}); err == nil {
+			t.Error("did not receive expected error")
+		}
+
+		if _, _, err := roleSessions(base, "", map[string]string{"": "arn:aws:iam::012345678901:role/x"}, roleOptions{}); err == nil {
+			t.Error("did not receive expected error")
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// target is a secrets backend created by backendFactory, along with the name of the backend, and the region
+// and AWS session the backend was created with.  The region is empty when using the default region.  Targets
+// created for a key name prefix mapped to an IAM role only store the keys starting with the prefix.
+type target struct {
+	name    string
+	region  string
+	prefix  string
+	session *session.Session
+	SecretBackender
+}
+
+// targets holds the backends selected using the -s, -regions, and -role-map options.  Every secret is stored
+// using each backend and region, in the account of the role mapped to the key name prefix.
+var targets []target
+
+// activeTargets returns the selected backends, or the backend in sb if validateBackend() was not called
+func activeTargets() []target {
+	if len(targets) > 0 {
+		return targets
+	}
+	return []target{{name: backendArg, session: ses, SecretBackender: sb}}
+}
+
+// String returns the description of the target used in log messages and reports
+func (t target) String() string {
+	s := fmt.Sprintf("%s backend", t.name)
+	if len(t.region) > 0 {
+		s += " in " + t.region
+	}
+
+	if len(t.prefix) > 0 {
+		s += " for " + t.prefix
+	}
+	return s
+}
+
+// owns returns true if the key is stored using the target.  For each backend and region, a key is stored using
+// the target with the longest prefix which matches the key name, like the KMS keys in kmsKeyFor().
+func (t target) owns(key string) bool {
+	if !strings.HasPrefix(key, t.prefix) {
+		return false
+	}
+
+	for _, o := range targets {
+		if o.name == t.name && o.region == t.region && len(o.prefix) > len(t.prefix) && strings.HasPrefix(key, o.prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// where returns the suffix for log messages about the target, naming the backend only when there is more than one
+func (t target) where() string {
+	if len(targets) > 1 {
+		return " in " + t.String()
+	}
+	return ""
+}
+
+// key returns the name used to store the key using the target.  Names created using the -prefix and -key-template
+// options are normalized for the backend, other names are used as-is.
+func (t target) key(k string) string {
+	if namer == nil {
+		return k
+	}
+	return normalizeKey(t.name, k)
+}
+
+// secret returns the secret using the name from key(), copying the secret if the name is changed
+func (t target) secret(s *secret) *secret {
+	k := t.key(s.key)
+	if k == s.key {
+		return s
+	}
+
+	c := *s
+	c.key = k
+	return &c
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// SSM limits the length of the parameter ARN, which includes the name, to 1011 characters
+	ssmMaxNameLen = 1011
+	ssmMaxDepth   = 15
+	// Secrets Manager names are limited to 512 characters
+	secretsMaxNameLen = 512
+	// S3 object keys are limited to 1024 bytes of UTF-8
+	s3MaxKeyLen = 1024
+	// DynamoDB partition key values are limited to 2048 bytes
+	dynamoMaxKeyLen = 2048
+)
+
+var (
+	ssmNameChars     = regexp.MustCompile(`^[a-zA-Z0-9_./-]+$`)
+	secretsNameChars = regexp.MustCompile(`^[a-zA-Z0-9/_+=.@-]+$`)
+)
+
+// validateKeyName checks the key name against the naming rules of the backend, returning an error describing the
+// first rule the name breaks.  Backends without naming rules accept any name.
+func validateKeyName(be, key string) error {
+	if len(key) < 1 {
+		return fmt.Errorf("name is empty")
+	}
+
+	switch be {
+	case ssmSvc:
+		if !ssmNameChars.MatchString(key) {
+			return fmt.Errorf("name may only contain letters, numbers, and the characters _ . - /")
+		}
+
+		if len(key) > ssmMaxNameLen {
+			return fmt.Errorf("name is longer than %d characters", ssmMaxNameLen)
+		}
+
+		if strings.Contains(key, "/") && !strings.HasPrefix(key, "/") {
+			return fmt.Errorf("names using a path hierarchy must start with /")
+		}
+
+		if n := strings.Count(key, "/"); n > ssmMaxDepth {
+			return fmt.Errorf("path hierarchy has %d levels, the maximum is %d", n, ssmMaxDepth)
+		}
+
+		// names without a path hierarchy may not start with aws or ssm, and names using one may not use aws or ssm as
+		// the whole first level, so /awsapp/x is allowed
+		if strings.HasPrefix(key, "/") {
+			first := strings.SplitN(key[1:], "/", 2)[0]
+			if strings.EqualFold(first, "aws") || strings.EqualFold(first, "ssm") {
+				return fmt.Errorf("paths with aws or ssm as the first level are reserved")
+			}
+		} else if lower := strings.ToLower(key); strings.HasPrefix(lower, "aws") || strings.HasPrefix(lower, "ssm") {
+			return fmt.Errorf("names starting with aws or ssm are reserved")
+		}
+	case secretsSvc:
+		if !secretsNameChars.MatchString(key) {
+			return fmt.Errorf("name may only contain letters, numbers, and the characters / _ + = . @ -")
+		}
+
+		if len(key) > secretsMaxNameLen {
+			return fmt.Errorf("name is longer than %d characters", secretsMaxNameLen)
+		}
+	case s3Svc:
+		if !utf8.ValidString(key) {
+			return fmt.Errorf("name is not valid UTF-8")
+		}
+
+		if len(key) > s3MaxKeyLen {
+			return fmt.Errorf("name is longer than %d bytes", s3MaxKeyLen)
+		}
+	case dynamoSvc:
+		if len(key) > dynamoMaxKeyLen {
+			return fmt.Errorf("name is longer than %d bytes", dynamoMaxKeyLen)
+		}
+	}
+
+	return nil
+}
+
+// validateSecrets checks the name of each secret against the naming rules of each backend which stores it,
+// returning a description of each invalid name.  Backends in multiple regions or accounts use the same rules, so
+// each name is only reported once for each backend.
+func validateSecrets(ts []target, secrets []*secret) []string {
+	var report []string
+	seen := make(map[string]bool)
+
+	for _, s := range secrets {
+		for _, t := range ts {
+			k := t.key(s.key)
+			if !t.owns(k) || seen[t.name+"\x00"+k] {
+				continue
+			}
+			seen[t.name+"\x00"+k] = true
+
+			if err := validateKeyName(t.name, k); err != nil {
+				report = append(report, fmt.Sprintf("invalid key name %s for %s backend: %v", k, t.name, err))
+			}
+		}
+	}
+
+	return report
+}
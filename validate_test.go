@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateKeyName(t *testing.T) {
+	deep := strings.Repeat("/a", ssmMaxDepth)
+
+	tests := []struct {
+		be, key string
+		valid   bool
+	}{
+		{ssmSvc, "/prod/app/db_password", true},
+		{ssmSvc, "db_password", true},
+		{ssmSvc, "db-password.v1", true},
+		{ssmSvc, deep, true},
+		{ssmSvc, deep + "/a", false},
+		{ssmSvc, "prod/app/db_password", false},
+		{ssmSvc, "/prod/app/db password", false},
+		{ssmSvc, "/prod/app/db:password", false},
+		{ssmSvc, "/aws/reference/x", false},
+		{ssmSvc, "SSM-key", false},
+		{ssmSvc, "awsTestParameter", false},
+		{ssmSvc, "SSM-testparameter", false},
+		{ssmSvc, "/SSM/key", false},
+		{ssmSvc, "aws", false},
+		{ssmSvc, "/awsapp/db", true},
+		{ssmSvc, "/ssmtools/x", true},
+		{ssmSvc, "/" + strings.Repeat("a", ssmMaxNameLen), false},
+		{ssmSvc, "", false},
+		{secretsSvc, "prod/app/db_password", true},
+		{secretsSvc, "prod/app/user+name=x@y.z-1", true},
+		{secretsSvc, "prod/app/db password", false},
+		{secretsSvc, "prod/app/db:password", false},
+		{secretsSvc, strings.Repeat("a", secretsMaxNameLen+1), false},
+		{s3Svc, "/prod/app/db password:x", true},
+		{s3Svc, strings.Repeat("a", s3MaxKeyLen+1), false},
+		{s3Svc, "prod/\xff", false},
+		{dynamoSvc, "prod/app/db password", true},
+		{dynamoSvc, strings.Repeat("a", dynamoMaxKeyLen+1), false},
+		{fileSvc, "any name: at all", true},
+		{vaultSvc, "any name: at all", true},
+	}
+
+	for _, tc := range tests {
+		err := validateKeyName(tc.be, tc.key)
+		if tc.valid && err != nil {
+			t.Errorf("unexpected error for %s key %.40s: %v", tc.be, tc.key, err)
+		}
+
+		if !tc.valid && err == nil {
+			t.Errorf("did not receive expected error for %s key %.40s", tc.be, tc.key)
+		}
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	ts := []target{
+		{name: ssmSvc, region: "us-east-1"},
+		{name: ssmSvc, region: "us-west-2"},
+		{name: secretsSvc},
+	}
+	secrets := []*secret{{key: "/app/good"}, {key: "/app/bad:key"}, {key: "app/ssm-only"}}
+
+	report := validateSecrets(ts, secrets)
+	if len(report) != 3 {
+		t.Errorf("unexpected report: %v", report)
+		return
+	}
+
+	for i, v := range []string{"/app/bad:key for ssm", "/app/bad:key for secretsmanager", "app/ssm-only for ssm"} {
+		if !strings.Contains(report[i], v) {
+			t.Errorf("unexpected report line %s, wanted %s", report[i], v)
+		}
+	}
+}